@@ -10,11 +10,16 @@ import (
 )
 
 type args struct {
-	Login   *nboci.LoginArgs  `arg:"subcommand:login" help:"login to registry"`
-	Logout  *nboci.LogoutArgs `arg:"subcommand:logout" help:"logout from registry"`
-	Push    *nboci.PushArgs   `arg:"subcommand:push" help:"push files to registry"`
-	List    *nboci.ListArgs   `arg:"subcommand:list" help:"list available tags in registry"`
-	Pull    *nboci.PullArgs   `arg:"subcommand:pull" help:"pull files to registry"`
+	Login   *nboci.LoginArgs           `arg:"subcommand:login" help:"login to registry"`
+	Logout  *nboci.LogoutArgs          `arg:"subcommand:logout" help:"logout from registry"`
+	Push    *nboci.PushArgs            `arg:"subcommand:push" help:"push files to registry"`
+	List    *nboci.ListArgs            `arg:"subcommand:list" help:"list available tags in registry"`
+	Catalog *nboci.CatalogArgs         `arg:"subcommand:catalog" help:"find repositories with netboot artifacts in registry"`
+	Pull    *nboci.PullArgs            `arg:"subcommand:pull" help:"pull files to registry"`
+	Inspect *nboci.InspectArgs         `arg:"subcommand:inspect" help:"show details of a netboot artifact"`
+	DHCP    *nboci.DHCPConfigArgs      `arg:"subcommand:dhcp-config" help:"generate DHCP server configuration"`
+	Migrate *nboci.MigrateArgs         `arg:"subcommand:migrate" help:"rewrite legacy artifacts to the current schema"`
+	Verify  *nboci.VerifyChecksumsArgs `arg:"subcommand:verify" help:"verify pulled files against SHA256SUMS offline"`
 	Verbose bool
 }
 
@@ -51,10 +56,20 @@ func main() {
 		nboci.Logout(ctx, *args.Logout)
 	} else if args.List != nil {
 		nboci.List(ctx, *args.List)
+	} else if args.Catalog != nil {
+		nboci.Catalog(ctx, *args.Catalog)
 	} else if args.Push != nil {
 		nboci.Push(ctx, *args.Push)
 	} else if args.Pull != nil {
 		nboci.Pull(ctx, *args.Pull)
+	} else if args.Inspect != nil {
+		nboci.Inspect(ctx, *args.Inspect)
+	} else if args.DHCP != nil {
+		nboci.DHCPConfig(*args.DHCP)
+	} else if args.Migrate != nil {
+		nboci.Migrate(ctx, *args.Migrate)
+	} else if args.Verify != nil {
+		nboci.VerifyChecksums(ctx, *args.Verify)
 	} else {
 		parser.Fail("unknown subcommand")
 	}
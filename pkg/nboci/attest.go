@@ -0,0 +1,223 @@
+package nboci
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/in-toto/in-toto-golang/in_toto"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sigstore/cosign/v2/cmd/cosign/cli/attest"
+	"github.com/sigstore/cosign/v2/cmd/cosign/cli/options"
+	"github.com/sigstore/cosign/v2/pkg/oci"
+	ociremote "github.com/sigstore/cosign/v2/pkg/oci/remote"
+	"github.com/sigstore/cosign/v2/pkg/types"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+// predicateType returns predicate URI of a predicate type name (slsaprovenance, spdxjson,
+// cyclonedx and other cosign names) or validates a predicate URI.
+func predicateType(t string) (string, error) {
+	return options.ParsePredicateType(t)
+}
+
+// checkAttestations validates predicate types and files of push attestations.
+func (args PushArgs) checkAttestations() error {
+	if len(args.Attestation) == 0 {
+		return nil
+	}
+	if !args.isSigning() {
+		return errors.New("attestations require --sign-key or --identity-token")
+	}
+	for t, f := range args.Attestation {
+		if _, err := predicateType(t); err != nil {
+			return err
+		}
+		if _, err := os.Stat(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pushAttestations generates in-toto statements of predicate files about the subject manifest
+// and attaches them in the cosign attestation format, a DSSE envelope signed the same way as the
+// manifest, so they can be verified with cosign verify-attestation too.
+func pushAttestations(ctx context.Context, repo *remote.Repository, subject ocispec.Descriptor, args PushArgs) error {
+	types := make([]string, 0, len(args.Attestation))
+	for t := range args.Attestation {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+
+	ref := reference(repo, subject.Digest.String())
+	for _, t := range types {
+		uri, err := predicateType(t)
+		if err != nil {
+			return err
+		}
+		cmd := attest.AttestCommand{
+			KeyOpts:         args.keyOpts(),
+			RegistryOptions: args.registryOptions(),
+			PredicatePath:   args.Attestation[t],
+			PredicateType:   t,
+			Replace:         true,
+			Timeout:         options.DefaultTimeout,
+			TlogUpload:      !args.NoTlog,
+			RekorEntryType:  "dsse",
+		}
+
+		Print("attaching attestation", uri)
+		if err := cmd.Exec(ctx, ref); err != nil {
+			return fmt.Errorf("cannot push attestation %s: %w", t, err)
+		}
+	}
+
+	return nil
+}
+
+// listAttestations returns cosign attestations attached to a manifest without verifying them.
+func listAttestations(ctx context.Context, repo *remote.Repository, desc ocispec.Descriptor, plain bool) ([]oci.Signature, error) {
+	ref, err := name.NewDigest(reference(repo, desc.Digest.String()), nameOptions(plain)...)
+	if err != nil {
+		return nil, err
+	}
+	ro := registryOptions(plain)
+	opts, err := ro.ClientOpts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	se, err := ociremote.SignedEntity(ref, opts...)
+	if err != nil {
+		return nil, err
+	}
+	atts, err := se.Attestations()
+	if err != nil {
+		return nil, err
+	}
+
+	return atts.Get()
+}
+
+// attestationStatement returns in-toto statement of a cosign attestation.
+func attestationStatement(att oci.Signature) (*in_toto.StatementHeader, error) {
+	payload, err := att.Payload()
+	if err != nil {
+		return nil, err
+	}
+	var envelope struct {
+		PayloadType string `json:"payloadType"`
+		Payload     string `json:"payload"`
+	}
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid DSSE envelope: %w", err)
+	}
+	if envelope.PayloadType != types.IntotoPayloadType {
+		return nil, fmt.Errorf("unsupported payload type %s", envelope.PayloadType)
+	}
+	data, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DSSE payload: %w", err)
+	}
+
+	var statement in_toto.StatementHeader
+	if err := json.Unmarshal(data, &statement); err != nil {
+		return nil, err
+	}
+	if statement.Type != in_toto.StatementInTotoV01 && statement.Type != "https://in-toto.io/Statement/v1" {
+		return nil, fmt.Errorf("unsupported statement type %s", statement.Type)
+	}
+
+	return &statement, nil
+}
+
+// attestationVerifier is a verifier which also verifies signed attestations of a manifest and
+// returns their predicate types.
+type attestationVerifier interface {
+	VerifyAttestations(ctx context.Context, repo *remote.Repository, desc ocispec.Descriptor) ([]string, error)
+}
+
+// attestationRequirements returns predicate URIs required for artifacts of the OS in the
+// repository, with verifiers of attestation signatures and the policy mode.
+func (args PullArgs) attestationRequirements(repo *remote.Repository, osName string) ([]string, []Verifier, string, error) {
+	types, verifiers, mode := args.RequireAttestation, []Verifier{args.Verifier}, PolicyEnforce
+	if args.Policy != nil {
+		rule := args.Policy.match(repo.Reference.Registry+"/"+repo.Reference.Repository, osName)
+		if rule == nil {
+			return nil, nil, mode, nil
+		}
+		types, verifiers, mode = rule.Attestations, rule.verifiers, rule.Mode
+	}
+
+	uris := make([]string, 0, len(types))
+	for _, t := range types {
+		uri, err := predicateType(t)
+		if err != nil {
+			return nil, nil, mode, err
+		}
+		uris = append(uris, uri)
+	}
+
+	return uris, verifiers, mode, nil
+}
+
+// verifyAttestations checks that every required predicate type is attested by a signed statement
+// about the manifest and returns the verified predicate types. False is returned when the
+// artifact is skipped by policy.
+func verifyAttestations(ctx context.Context, repo *remote.Repository, desc ocispec.Descriptor, osName string, args PullArgs) ([]string, bool, error) {
+	required, verifiers, mode, err := args.attestationRequirements(repo, osName)
+	if err != nil || len(required) == 0 {
+		return nil, true, err
+	}
+
+	verified := make([]string, 0, len(required))
+	errs := make([]error, 0)
+	for _, v := range verifiers {
+		av, ok := v.(attestationVerifier)
+		if !ok {
+			continue
+		}
+		uris, err := av.VerifyAttestations(ctx, repo, desc)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, uri := range uris {
+			if slices.Contains(required, uri) && !slices.Contains(verified, uri) {
+				Debug("verified attestation", uri)
+				verified = append(verified, uri)
+			}
+		}
+	}
+
+	missing := slices.DeleteFunc(slices.Clone(required), func(uri string) bool {
+		return slices.Contains(verified, uri)
+	})
+	if len(missing) == 0 {
+		Printf("verified attestations of %s: %s\n", shortDigest(desc.Digest.String()), strings.Join(verified, ", "))
+		return verified, true, nil
+	}
+
+	err = fmt.Errorf("missing verified attestations %s of %s", strings.Join(missing, ", "), desc.Digest)
+	if len(errs) > 0 {
+		err = fmt.Errorf("%w: %w", err, errors.Join(errs...))
+	}
+	switch mode {
+	case PolicyWarn:
+		ErrorErr(err, "pulling artifact in warn mode")
+		return verified, true, nil
+	case PolicySkip:
+		Print("skipping artifact without attestations", desc.Digest.String())
+		Debug(err.Error())
+		return nil, false, nil
+	}
+
+	return nil, false, err
+}
@@ -4,7 +4,10 @@ import (
 	"os"
 	"path"
 
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
 	"oras.land/oras-go/v2/registry/remote/credentials"
+	"oras.land/oras-go/v2/registry/remote/retry"
 )
 
 func configPath() string {
@@ -26,3 +29,37 @@ func NewStore() credentials.Store {
 	
 	return s
 }
+
+// newRepository creates authenticated repository from a reference with optional tag or digest.
+func newRepository(reference string, plain bool) *remote.Repository {
+	repo, err := remote.NewRepository(reference)
+	if err != nil {
+		FatalErr(err, "cannot create repository")
+	}
+
+	repo.Client = &auth.Client{
+		Client:     retry.DefaultClient,
+		Cache:      auth.NewCache(),
+		Credential: credentials.Credential(NewStore()),
+	}
+	repo.PlainHTTP = plain
+
+	return repo
+}
+
+// newRegistry creates authenticated registry client.
+func newRegistry(name string, plain bool) *remote.Registry {
+	reg, err := remote.NewRegistry(name)
+	if err != nil {
+		FatalErr(err, "cannot create registry")
+	}
+
+	reg.Client = &auth.Client{
+		Client:     retry.DefaultClient,
+		Cache:      auth.NewCache(),
+		Credential: credentials.Credential(NewStore()),
+	}
+	reg.PlainHTTP = plain
+
+	return reg
+}
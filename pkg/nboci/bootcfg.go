@@ -0,0 +1,64 @@
+package nboci
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// GeneratedConfigMarker is the first line of boot configuration files written by nboci, files
+// without it were modified or created by the user and are never overwritten.
+const GeneratedConfigMarker = "# generated by nboci, remove this line to prevent overwriting"
+
+// writeGrubConfig writes grub configuration booting kernel and initrd of the netboot config. Grub
+// loads grub.cfg from the directory it was loaded from, which is exposed as $cmdpath.
+func writeGrubConfig(filename string, c *NetbootConfig) error {
+	if !isGeneratedConfig(filename) {
+		Debug("skipping user modified", filename)
+		return nil
+	}
+
+	if strings.ContainsFunc(c.Kernel.Args, unicode.IsControl) {
+		return errors.New("kernel arguments contain control characters")
+	}
+	args := strings.Fields(c.Kernel.Args)
+	for i, a := range args {
+		args[i] = grubQuote(a)
+	}
+
+	str := strings.Builder{}
+	str.WriteString(GeneratedConfigMarker + "\n")
+	str.WriteString("set timeout=0\n")
+	fmt.Fprintf(&str, "menuentry %s {\n", grubQuote(fmt.Sprintf("%s %s %s", c.OS.Name, c.OS.Version, c.OS.Architecture)))
+	fmt.Fprintf(&str, "  linux $cmdpath/%s\n", strings.Join(append([]string{grubQuote(c.Kernel.File)}, args...), " "))
+	if c.Kernel.Initrd != "" {
+		fmt.Fprintf(&str, "  initrd $cmdpath/%s\n", grubQuote(c.Kernel.Initrd))
+	}
+	str.WriteString("}\n")
+
+	Debug("writing", filename)
+	return os.WriteFile(filename, []byte(str.String()), 0644)
+}
+
+// grubQuote quotes a word for grub script, single quotes preserve everything except the single
+// quote itself which is closed, escaped and reopened.
+func grubQuote(word string) string {
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// isGeneratedConfig returns true when file does not exist or it was generated by nboci.
+func isGeneratedConfig(filename string) bool {
+	f, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return true
+	} else if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	return scanner.Scan() && scanner.Text() == GeneratedConfigMarker
+}
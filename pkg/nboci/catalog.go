@@ -0,0 +1,149 @@
+package nboci
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+type CatalogArgs struct {
+	Registry string `arg:"positional,required" help:"registry" placeholder:"REGISTRY"`
+	Prefix   string `arg:"-p,--prefix" help:"only repositories with the prefix" placeholder:"PREFIX"`
+	Plain    bool   `arg:"-N,--plain" help:"plain HTTP (insecure)"`
+	Output   string `arg:"-o,--output" default:"text" help:"output format (text, json)" placeholder:"FORMAT"`
+	PageSize int    `arg:"--page-size" help:"number of repositories requested per catalog page (default: registry)"`
+}
+
+// CatalogRepository is a repository with netboot artifacts.
+type CatalogRepository struct {
+	Repository string      `json:"repository"`
+	Systems    []CatalogOS `json:"systems"`
+}
+
+// CatalogOS is an OS name and version with architectures and tags providing them.
+type CatalogOS struct {
+	Name          string   `json:"name"`
+	Version       string   `json:"version"`
+	Architectures []string `json:"architectures"`
+	Tags          []string `json:"tags"`
+}
+
+func Catalog(ctx context.Context, args CatalogArgs) {
+	if args.Output != "text" && args.Output != "json" {
+		Fatal("unknown output format", args.Output)
+	}
+
+	reg := newRegistry(args.Registry, args.Plain)
+	reg.RepositoryListPageSize = args.PageSize
+
+	names := make([]string, 0)
+	err := reg.Repositories(ctx, "", func(page []string) error {
+		for _, name := range page {
+			if strings.HasPrefix(name, args.Prefix) {
+				names = append(names, name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		FatalErr(err, "cannot list repositories")
+	}
+	slices.Sort(names)
+
+	result := make([]CatalogRepository, 0)
+	for _, name := range names {
+		Debug("scanning", name)
+		systems, err := catalogRepository(ctx, args.Registry+"/"+name, args.Plain)
+		if err != nil {
+			ErrorErr(err, "cannot scan", name)
+			continue
+		}
+		if len(systems) == 0 {
+			Debug("no netboot artifacts in", name)
+			continue
+		}
+		result = append(result, CatalogRepository{Repository: args.Registry + "/" + name, Systems: systems})
+	}
+
+	if args.Output == "json" {
+		buf, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			FatalErr(err, "cannot marshal output")
+		}
+		Print(string(buf))
+		return
+	}
+
+	for _, r := range result {
+		Print(r.Repository)
+		for _, s := range r.Systems {
+			Printf("  %s %s %s\n", s.Name, s.Version, strings.Join(s.Architectures, ","))
+		}
+	}
+	Printf("%d of %d repositories contain netboot artifacts\n", len(result), len(names))
+}
+
+// catalogRepository returns OS and architecture combinations of netboot artifacts in a repository.
+func catalogRepository(ctx context.Context, reference string, plain bool) ([]CatalogOS, error) {
+	repo := newRepository(reference, plain)
+
+	tags := make([]string, 0)
+	err := repo.Tags(ctx, "", func(page []string) error {
+		tags = append(tags, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	systems := make([]CatalogOS, 0)
+	for _, tag := range tags {
+		if isHelperTag(tag) {
+			continue
+		}
+
+		info, err := fetchArtifactInfo(ctx, repo, tag)
+		if err != nil {
+			ErrorErr(err, "cannot fetch", reference+":"+tag)
+			continue
+		}
+		if !info.isNetboot() {
+			continue
+		}
+
+		name, version := info.annotations[AnnotationOSName], info.annotations[AnnotationOSVersion]
+		i := slices.IndexFunc(systems, func(s CatalogOS) bool { return s.Name == name && s.Version == version })
+		if i < 0 {
+			systems = append(systems, CatalogOS{Name: name, Version: version, Architectures: make([]string, 0)})
+			i = len(systems) - 1
+		}
+		s := &systems[i]
+		s.Tags = append(s.Tags, tag)
+
+		archs := make([]string, 0)
+		if arch := info.annotations[AnnotationOSArch]; arch != "" {
+			archs = append(archs, arch)
+		}
+		for _, m := range info.manifests {
+			archs = append(archs, descriptorArchitecture(m))
+		}
+		for _, arch := range archs {
+			if arch != "" && !slices.Contains(s.Architectures, arch) {
+				s.Architectures = append(s.Architectures, arch)
+			}
+		}
+	}
+
+	slices.SortFunc(systems, func(a, b CatalogOS) int {
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		return CompareVersions(a.Version, b.Version)
+	})
+	for _, s := range systems {
+		slices.Sort(s.Architectures)
+	}
+
+	return systems, nil
+}
@@ -0,0 +1,219 @@
+package nboci
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sigstore/cosign/v2/cmd/cosign/cli/generate"
+	sigs "github.com/sigstore/cosign/v2/pkg/signature"
+	"github.com/sigstore/sigstore/pkg/signature"
+)
+
+// ChecksumsFile is written into every pulled directory with sha256sum compatible digests of the
+// pulled files, ChecksumsSignatureFile is its detached cosign blob signature.
+const (
+	ChecksumsFile          = "SHA256SUMS"
+	ChecksumsSignatureFile = ChecksumsFile + ".sig"
+)
+
+type VerifyChecksumsArgs struct {
+	Directory    []string `arg:"positional,required" help:"pulled directories, searched for SHA256SUMS files" placeholder:"DIRECTORY"`
+	SignatureKey string   `arg:"-k,--signature-key" help:"require SHA256SUMS signatures of cosign public key" placeholder:"COSIGN_PUBLIC_FILE"`
+}
+
+// loadChecksumsSigner loads a cosign private key for signing checksum files, or returns nil when
+// there is no key.
+func loadChecksumsSigner(ctx context.Context, keyRef string) (signature.Signer, error) {
+	if keyRef == "" {
+		return nil, nil
+	}
+
+	signer, err := sigs.SignerFromKeyRef(ctx, keyRef, generate.GetPass)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load checksums signing key: %w", err)
+	}
+	return signer, nil
+}
+
+// writeChecksums writes digests of files into the checksum file of a directory and signs it with
+// the signer when it is given. Nothing is written when the file is up to date and signed, or when
+// there are no files.
+func writeChecksums(dirname string, digests map[string]string, signer signature.Signer) error {
+	if len(digests) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(digests))
+	for n := range digests {
+		names = append(names, n)
+	}
+	slices.Sort(names)
+
+	var buf bytes.Buffer
+	for _, n := range names {
+		fmt.Fprintf(&buf, "%s  %s\n", strings.TrimPrefix(digests[n], "sha256:"), n)
+	}
+	data := buf.Bytes()
+
+	filename := filepath.Join(dirname, ChecksumsFile)
+	sigFilename := filepath.Join(dirname, ChecksumsSignatureFile)
+	existing, _ := os.ReadFile(filename)
+	changed := !bytes.Equal(existing, data)
+	if changed {
+		Debug("writing", filename)
+		if err := os.WriteFile(filename, data, 0644); err != nil {
+			return err
+		}
+	}
+
+	if signer == nil {
+		// signature of the previous content would not verify
+		if changed {
+			if err := os.Remove(sigFilename); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	}
+	if _, err := os.Stat(sigFilename); err == nil && !changed {
+		return nil
+	}
+
+	sig, err := signer.SignMessage(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("cannot sign %s: %w", filename, err)
+	}
+	Debug("signing", filename)
+	return os.WriteFile(sigFilename, []byte(base64.StdEncoding.EncodeToString(sig)), 0644)
+}
+
+// readChecksums parses a checksum file into file names and digests.
+func readChecksums(filename string) (map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	digests := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		// text mode "DIGEST  NAME" or binary mode "DIGEST *NAME"
+		sum, name, ok := strings.Cut(line, " ")
+		if !ok || len(sum) != 64 || len(name) < 2 || (name[0] != ' ' && name[0] != '*') {
+			return nil, fmt.Errorf("%s line %d: invalid checksum line", filename, n)
+		}
+		name = name[1:]
+		if name == "" || name == "." || name == ".." || name != filepath.Base(name) {
+			return nil, fmt.Errorf("%s line %d: file %s is outside of the directory", filename, n, name)
+		}
+		digests[name] = "sha256:" + strings.ToLower(sum)
+	}
+
+	return digests, scanner.Err()
+}
+
+// checkChecksums verifies signature of the checksum file of a directory when the verifier is
+// given and digests of all listed files, returning the number of files.
+func checkChecksums(dirname string, verifier signature.Verifier) (int, error) {
+	filename := filepath.Join(dirname, ChecksumsFile)
+	if verifier != nil {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return 0, err
+		}
+		b64, err := os.ReadFile(filepath.Join(dirname, ChecksumsSignatureFile))
+		if err != nil {
+			return 0, fmt.Errorf("missing signature: %w", err)
+		}
+		sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b64)))
+		if err != nil {
+			return 0, fmt.Errorf("invalid signature: %w", err)
+		}
+		if err := verifier.VerifySignature(bytes.NewReader(sig), bytes.NewReader(data)); err != nil {
+			return 0, err
+		}
+	}
+
+	digests, err := readChecksums(filename)
+	if err != nil {
+		return 0, err
+	}
+	names := make([]string, 0, len(digests))
+	for n := range digests {
+		names = append(names, n)
+	}
+	slices.Sort(names)
+
+	errs := make([]error, 0)
+	for _, n := range names {
+		digest, err := fileDigest(filepath.Join(dirname, n))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if digest != digests[n] {
+			errs = append(errs, fmt.Errorf("%s has digest %s, expected %s", n, digest, digests[n]))
+		}
+	}
+
+	return len(names), errors.Join(errs...)
+}
+
+// VerifyChecksums checks pulled directories against their checksum files without network access.
+func VerifyChecksums(ctx context.Context, args VerifyChecksumsArgs) {
+	var verifier signature.Verifier
+	if args.SignatureKey != "" {
+		var err error
+		verifier, err = sigs.PublicKeyFromKeyRef(ctx, args.SignatureKey)
+		if err != nil {
+			FatalErr(err, "cannot load public key")
+		}
+	}
+
+	dirs := make([]string, 0)
+	for _, d := range args.Directory {
+		err := filepath.WalkDir(d, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && entry.Name() == ChecksumsFile {
+				dirs = append(dirs, filepath.Dir(path))
+			}
+			return nil
+		})
+		if err != nil {
+			FatalErr(err, "cannot read", d)
+		}
+	}
+	if len(dirs) == 0 {
+		Fatal("no", ChecksumsFile, "files found")
+	}
+
+	failed := 0
+	for _, d := range dirs {
+		n, err := checkChecksums(d, verifier)
+		if err != nil {
+			ErrorErr(err, "verification of", d, "failed")
+			failed++
+			continue
+		}
+		Printf("verified %s: %d files\n", d, n)
+	}
+	if failed > 0 {
+		Fatalf("%d of %d directories failed verification", failed, len(dirs))
+	}
+}
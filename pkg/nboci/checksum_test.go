@@ -0,0 +1,156 @@
+package nboci
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sigstore/sigstore/pkg/signature"
+)
+
+// writeTestTree writes files into a new directory and returns it with digests of the files.
+func writeTestTree(t *testing.T, files map[string]string) (string, map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	digests := make(map[string]string, len(files))
+	for name, data := range files {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		digest, err := fileDigest(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests[name] = digest
+	}
+
+	return dir, digests
+}
+
+func TestChecksumsRoundTrip(t *testing.T) {
+	dir, digests := writeTestTree(t, map[string]string{"vmlinuz": "kernel", "initrd.img": "initrd", "shim.efi": "shim"})
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := signature.LoadECDSASignerVerifier(key, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeChecksums(dir, digests, signer); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ChecksumsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sorted sha256sum text mode
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || !strings.HasSuffix(lines[0], "  initrd.img") || !strings.HasSuffix(lines[2], "  vmlinuz") {
+		t.Fatalf("unexpected checksum file:\n%s", data)
+	}
+
+	read, err := readChecksums(filepath.Join(dir, ChecksumsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, digest := range digests {
+		if read[name] != digest {
+			t.Fatalf("read digest %s of %s, expected %s", read[name], name, digest)
+		}
+	}
+
+	if n, err := checkChecksums(dir, signer); err != nil || n != 3 {
+		t.Fatalf("verified %d files: %v", n, err)
+	}
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := signature.LoadECDSAVerifier(&other.PublicKey, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checkChecksums(dir, verifier); err == nil {
+		t.Fatal("signature of other key verified")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "vmlinuz"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checkChecksums(dir, nil); err == nil || !strings.Contains(err.Error(), "vmlinuz has digest") {
+		t.Fatalf("expected digest mismatch, got %v", err)
+	}
+
+	// changed content without signer removes the stale signature
+	delete(digests, "vmlinuz")
+	if err := writeChecksums(dir, digests, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ChecksumsSignatureFile)); !os.IsNotExist(err) {
+		t.Fatalf("stale signature was kept: %v", err)
+	}
+}
+
+func TestWriteChecksumsEmpty(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	if err := writeChecksums(dir, map[string]string{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("directory without files was written: %v", err)
+	}
+}
+
+func TestReadChecksums(t *testing.T) {
+	sum := strings.Repeat("ab", 32)
+	tests := []struct {
+		name  string
+		data  string
+		files []string
+		err   string
+	}{
+		{name: "text mode", data: sum + "  vmlinuz\n", files: []string{"vmlinuz"}},
+		{name: "binary mode", data: sum + " *shim.efi\n\n", files: []string{"shim.efi"}},
+		{name: "upper case digest", data: strings.ToUpper(sum) + "  a\n", files: []string{"a"}},
+		{name: "empty name", data: sum + "  \n", err: "invalid checksum line"},
+		{name: "dot", data: sum + "  .\n", err: "outside of the directory"},
+		{name: "dot dot", data: sum + "  ..\n", err: "outside of the directory"},
+		{name: "subdirectory", data: sum + "  sub/file\n", err: "outside of the directory"},
+		{name: "parent", data: sum + "  ../file\n", err: "outside of the directory"},
+		{name: "absolute", data: sum + "  /etc/passwd\n", err: "outside of the directory"},
+		{name: "short digest", data: "abcd  file\n", err: "line 1: invalid checksum line"},
+		{name: "missing name", data: sum + "\n", err: "invalid checksum line"},
+		{name: "bad separator", data: sum + " -file\n", err: "invalid checksum line"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			digests, err := readChecksums(writeTestFile(t, ChecksumsFile, []byte(tt.data)))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(digests) != len(tt.files) {
+				t.Fatalf("read %v", digests)
+			}
+			for _, f := range tt.files {
+				if digests[f] != "sha256:"+sum {
+					t.Fatalf("read %v", digests)
+				}
+			}
+		})
+	}
+}
@@ -6,8 +6,32 @@ import (
 )
 
 const UnknownArtifactType = "application/vnd.unknown.artifact.v1"
+const NetbootArtifactType = "application/vnd.pulpproject.netboot.artifact.v1"
+const NetbootSchemaVersion = "1"
 const EmptyType = "application/vnd.oci.empty.v1+json"
 const NetbootFileZstdMediaType = "application/x-netboot-file+zstd"
+const NetbootConfigMediaType = "application/vnd.pulpproject.netboot.config.v1+json"
+
+const (
+	AnnotationTitle            = "org.opencontainers.image.title"
+	AnnotationSchemaVersion    = "org.pulpproject.netboot.schema.version"
+	AnnotationOSName           = "org.pulpproject.netboot.os.name"
+	AnnotationOSVersion        = "org.pulpproject.netboot.os.version"
+	AnnotationOSArch           = "org.pulpproject.netboot.os.arch"
+	AnnotationEntryPoint       = "org.pulpproject.netboot.entrypoint"
+	AnnotationAltEntryPoint    = "org.pulpproject.netboot.altentrypoint"
+	AnnotationLegacyEntryPoint = "org.pulpproject.netboot.legacyentrypoint"
+	AnnotationKernel           = "org.pulpproject.netboot.kernel"
+	AnnotationInitrd           = "org.pulpproject.netboot.initrd"
+	AnnotationKernelArgs       = "org.pulpproject.netboot.kernel.args"
+	AnnotationKernelVersion    = "org.pulpproject.netboot.kernel.version"
+	AnnotationRPMs             = "org.pulpproject.netboot.rpms"
+	AnnotationSrcSize          = "org.pulpproject.netboot.src.size"
+	AnnotationSrcDigest        = "org.pulpproject.netboot.src.digest"
+	AnnotationSrcRPM           = "org.pulpproject.netboot.src.rpm"
+	AnnotationSrcSigner        = "org.pulpproject.netboot.src.signer"
+	AnnotationSrcSBAT          = "org.pulpproject.netboot.src.sbat"
+)
 
 var AlphanumRegexp regexp.Regexp
 var ArchRegexp regexp.Regexp
@@ -17,6 +41,25 @@ func init() {
 	ArchRegexp = *regexp.MustCompile(`^(x86_64|aarch64|ppc64|ppc64le)$`)
 }
 
+// IsNetbootArtifact returns true for netboot artifact type and for legacy manifests of unknown
+// artifact type which carry netboot annotations.
+func IsNetbootArtifact(artifactType string, annotations map[string]string) bool {
+	switch artifactType {
+	case NetbootArtifactType:
+		return true
+	case UnknownArtifactType, "":
+		_, ok := annotations[AnnotationOSName]
+		return ok
+	}
+
+	return false
+}
+
+// IsLegacyArtifact returns true for netboot manifests of unknown artifact type.
+func IsLegacyArtifact(artifactType string, annotations map[string]string) bool {
+	return artifactType != NetbootArtifactType && IsNetbootArtifact(artifactType, annotations)
+}
+
 func mkTempDir() string {
 	dir, err := os.MkdirTemp("", "oci-netboot-")
 	if err != nil {
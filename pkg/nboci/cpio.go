@@ -0,0 +1,159 @@
+package nboci
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const cpioTrailer = "TRAILER!!!"
+
+// cpioMaxName limits name size of cpio entries (PATH_MAX) read from untrusted archives.
+const cpioMaxName = 4096
+
+// Mode bits of cpio entries.
+const (
+	cpioModeType    = 0170000
+	cpioModeRegular = 0100000
+	cpioModeSymlink = 0120000
+)
+
+// CpioHeader is a header of a single newc (SVR4) cpio archive entry. Hardlinked files share the
+// device and inode number, only the last link carries the data.
+type CpioHeader struct {
+	Name     string
+	Mode     uint32
+	Size     int64
+	Inode    int64
+	Links    int64
+	DevMajor int64
+	DevMinor int64
+}
+
+func (h *CpioHeader) IsRegular() bool {
+	return h.Mode&cpioModeType == cpioModeRegular
+}
+
+func (h *CpioHeader) IsSymlink() bool {
+	return h.Mode&cpioModeType == cpioModeSymlink
+}
+
+// CpioReader reads newc (SVR4) cpio archives as used by initramfs and RPM payloads. It reads
+// exactly until the end of the trailer entry so concatenated archives can be read in a row.
+type CpioReader struct {
+	r       io.Reader
+	remain  int64
+	padding int64
+}
+
+func NewCpioReader(r io.Reader) *CpioReader {
+	return &CpioReader{r: r}
+}
+
+// IsCpioMagic returns true if the buffer starts with newc cpio magic.
+func IsCpioMagic(buf []byte) bool {
+	return bytes.HasPrefix(buf, []byte("070701")) || bytes.HasPrefix(buf, []byte("070702"))
+}
+
+// Next advances to the next entry, it returns io.EOF at the trailer entry.
+func (cr *CpioReader) Next() (*CpioHeader, error) {
+	if _, err := io.CopyN(io.Discard, cr.r, cr.remain+cr.padding); err != nil {
+		return nil, err
+	}
+	cr.remain, cr.padding = 0, 0
+
+	var hdr [110]byte
+	if _, err := io.ReadFull(cr.r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if !IsCpioMagic(hdr[:]) {
+		return nil, errors.New("invalid cpio header magic")
+	}
+
+	field := func(i int) (int64, error) {
+		return strconv.ParseInt(string(hdr[6+i*8:6+(i+1)*8]), 16, 64)
+	}
+	ino, err := field(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cpio inode: %w", err)
+	}
+	mode, err := field(1)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cpio mode: %w", err)
+	}
+	nlink, err := field(4)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cpio link count: %w", err)
+	}
+	size, err := field(6)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cpio file size: %w", err)
+	}
+	devmajor, err := field(7)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cpio device: %w", err)
+	}
+	devminor, err := field(8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cpio device: %w", err)
+	}
+	namesize, err := field(11)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cpio name size: %w", err)
+	}
+	if namesize > cpioMaxName {
+		return nil, fmt.Errorf("cpio name size %d exceeds %d bytes", namesize, cpioMaxName)
+	}
+
+	name := make([]byte, namesize+cpioPad(110+namesize))
+	if _, err := io.ReadFull(cr.r, name); err != nil {
+		return nil, err
+	}
+
+	h := &CpioHeader{
+		Name:     strings.TrimRight(string(name[:namesize]), "\x00"),
+		Mode:     uint32(mode),
+		Size:     size,
+		Inode:    ino,
+		Links:    nlink,
+		DevMajor: devmajor,
+		DevMinor: devminor,
+	}
+	cr.remain = size
+	cr.padding = cpioPad(size)
+
+	if h.Name == cpioTrailer {
+		cr.remain, cr.padding = 0, 0
+		return nil, io.EOF
+	}
+
+	return h, nil
+}
+
+func (cr *CpioReader) Read(p []byte) (int, error) {
+	if cr.remain <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > cr.remain {
+		p = p[:cr.remain]
+	}
+
+	n, err := cr.r.Read(p)
+	cr.remain -= int64(n)
+	if err == io.EOF && cr.remain > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func cpioPad(n int64) int64 {
+	return (4 - n%4) % 4
+}
+
+// cpioName returns normalized entry name without leading "./" or "/".
+func cpioName(name string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(name, "./"), "/")
+}
@@ -0,0 +1,140 @@
+package nboci
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// testCpioEntry is an entry of a generated newc archive.
+type testCpioEntry struct {
+	name  string
+	mode  uint32
+	data  string
+	ino   int // defaults to a unique inode
+	links int // defaults to a single link
+}
+
+// writeTestCpio returns a newc archive with the entries and the trailer.
+func writeTestCpio(entries ...testCpioEntry) []byte {
+	var buf bytes.Buffer
+	pad := func() {
+		for buf.Len()%4 != 0 {
+			buf.WriteByte(0)
+		}
+	}
+	write := func(ino int, e testCpioEntry) {
+		if e.ino != 0 {
+			ino = e.ino
+		}
+		fmt.Fprintf(&buf, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+			ino, e.mode, 0, 0, max(e.links, 1), 0, len(e.data), 0, 0, 0, 0, len(e.name)+1, 0)
+		buf.WriteString(e.name)
+		buf.WriteByte(0)
+		pad()
+		buf.WriteString(e.data)
+		pad()
+	}
+
+	for i, e := range entries {
+		write(i+1, e)
+	}
+	write(0, testCpioEntry{name: cpioTrailer})
+
+	return buf.Bytes()
+}
+
+func TestCpioReader(t *testing.T) {
+	archive := writeTestCpio(
+		testCpioEntry{name: ".", mode: 040755},
+		testCpioEntry{name: "./etc/os-release", mode: cpioModeRegular | 0644, data: "ID=fedora\n"},
+		testCpioEntry{name: "usr/lib/os-release", mode: cpioModeSymlink | 0777, data: "../../etc/os-release"},
+	)
+	// archives are read one after another
+	cr := NewCpioReader(bytes.NewReader(append(archive, writeTestCpio(testCpioEntry{name: "x", mode: cpioModeRegular, data: "abcde"})...)))
+
+	expected := []struct {
+		name    string
+		regular bool
+		symlink bool
+		data    string
+	}{
+		{".", false, false, ""},
+		{"./etc/os-release", true, false, "ID=fedora\n"},
+		{"usr/lib/os-release", false, true, "../../etc/os-release"},
+	}
+	for i, e := range expected {
+		h, err := cr.Next()
+		if err != nil {
+			t.Fatalf("entry %d: %v", i, err)
+		}
+		if h.Name != e.name || h.IsRegular() != e.regular || h.IsSymlink() != e.symlink {
+			t.Fatalf("entry %d: unexpected header %+v", i, h)
+		}
+		// the first entry with data is skipped without reading
+		if i == 1 {
+			continue
+		}
+		data, err := io.ReadAll(cr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != e.data {
+			t.Fatalf("entry %d: data %q, expected %q", i, data, e.data)
+		}
+	}
+	if _, err := cr.Next(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected EOF at trailer, got %v", err)
+	}
+
+	h, err := cr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(cr)
+	if h.Name != "x" || string(data) != "abcde" {
+		t.Fatalf("unexpected entry %q of second archive: %q", h.Name, data)
+	}
+}
+
+func TestCpioReaderErrors(t *testing.T) {
+	valid := writeTestCpio(testCpioEntry{name: "file", mode: cpioModeRegular, data: "data"})
+	long := writeTestCpio(testCpioEntry{name: "file", mode: cpioModeRegular})
+	copy(long[6+11*8:], fmt.Sprintf("%08x", cpioMaxName+1))
+
+	tests := []struct {
+		name    string
+		archive []byte
+		err     string
+	}{
+		{"bad magic", append([]byte("070707"), valid[6:]...), "invalid cpio header magic"},
+		{"bad mode", append(append([]byte{}, valid[:14]...), append([]byte("zzzzzzzz"), valid[22:]...)...), "invalid cpio mode"},
+		{"name too long", long, "exceeds"},
+		{"truncated header", valid[:50], "unexpected EOF"},
+		{"truncated name", valid[:112], "unexpected EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewCpioReader(bytes.NewReader(tt.archive)).Next()
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestCpioName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"./etc/os-release":    "etc/os-release",
+		"/etc/os-release":     "etc/os-release",
+		"etc/os-release":      "etc/os-release",
+		"/usr/lib/os-release": "usr/lib/os-release",
+	} {
+		if n := cpioName(name); n != expected {
+			t.Errorf("cpioName(%q) = %q, expected %q", name, n, expected)
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package nboci
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"debug/elf"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// KernelInfo is information detected from a kernel image.
+type KernelInfo struct {
+	Version      string
+	Architecture string
+}
+
+// OSRelease is information detected from os-release file.
+type OSRelease struct {
+	Name    string
+	Version string
+}
+
+var linuxVersionRegexp = regexp.MustCompile(`Linux version (\S+)`)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// maxDecompressedKernel limits the size of decompressed kernel payloads, larger payloads are
+// refused. It is a variable so tests can lower it.
+var maxDecompressedKernel int64 = 512 * 1024 * 1024
+
+// detectMetadata fills empty OS name, version and architecture from the kernel and initrd files
+// and fails when explicitly provided values contradict the detected ones.
+func detectMetadata(args *PushArgs) error {
+	kernel := findFile(args.File, args.Kernel, "vmlinuz", "vmlinux", "bzImage", "Image")
+	if kernel == "" {
+		return errors.New("cannot find kernel file to detect metadata from, use --kernel")
+	}
+
+	Debug("detecting kernel metadata from", kernel)
+	ki, err := DetectKernel(kernel)
+	if err != nil {
+		return fmt.Errorf("cannot detect kernel metadata from %s: %w", kernel, err)
+	}
+	Debug("detected kernel", ki.Version, ki.Architecture)
+
+	if args.Architecture != "" && args.Architecture != ki.Architecture {
+		return fmt.Errorf("architecture %s contradicts detected kernel architecture %s", args.Architecture, ki.Architecture)
+	}
+	args.Architecture = ki.Architecture
+	args.KernelVersion = ki.Version
+
+	initrd := findFile(args.File, args.Initrd, "initrd", "initramfs")
+	if initrd == "" {
+		Debug("no initrd file found, skipping os-release detection")
+		return nil
+	}
+
+	Debug("detecting os-release from", initrd)
+	osr, err := DetectInitrdOSRelease(initrd)
+	if err != nil {
+		return fmt.Errorf("cannot detect os-release from %s: %w", initrd, err)
+	}
+	Debug("detected os-release", osr.Name, osr.Version)
+
+	if args.Name != "" && args.Name != osr.Name {
+		return fmt.Errorf("name %s contradicts detected name %s", args.Name, osr.Name)
+	}
+	if args.Version != "" && args.Version != osr.Version && !strings.HasPrefix(args.Version, osr.Version+".") {
+		return fmt.Errorf("version %s contradicts detected version %s", args.Version, osr.Version)
+	}
+	if args.Name == "" {
+		args.Name = osr.Name
+	}
+	if args.Version == "" {
+		args.Version = osr.Version
+	}
+
+	return nil
+}
+
+// findFile returns the file with base name equal to name or the first file with one of the
+// prefixes when name is empty.
+func findFile(files []string, name string, prefixes ...string) string {
+	for _, f := range files {
+		base := filepath.Base(f)
+		if name != "" {
+			if base == name {
+				return f
+			}
+			continue
+		}
+
+		for _, p := range prefixes {
+			if strings.HasPrefix(base, p) {
+				return f
+			}
+		}
+	}
+
+	return ""
+}
+
+// DetectKernel reads kernel version and architecture from x86 bzImage, ARM64 Image, EFI zboot,
+// PE or ELF kernel image.
+func DetectKernel(filename string) (*KernelInfo, error) {
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return detectKernel(buf, 0)
+}
+
+func detectKernel(buf []byte, depth int) (*KernelInfo, error) {
+	if depth > 2 {
+		return nil, errors.New("too many nested compressed payloads")
+	}
+
+	// x86 boot protocol
+	if len(buf) > 0x240 && string(buf[0x202:0x206]) == "HdrS" {
+		ki := &KernelInfo{}
+		if binary.LittleEndian.Uint16(buf[0x236:])&0x1 == 0 {
+			return nil, errors.New("32-bit x86 kernels are not supported")
+		}
+		ki.Architecture = "x86_64"
+
+		ptr := int(binary.LittleEndian.Uint16(buf[0x20e:])) + 0x200
+		if ptr > 0x200 && ptr < len(buf) {
+			if end := bytes.IndexByte(buf[ptr:], 0); end > 0 {
+				if fields := strings.Fields(string(buf[ptr : ptr+end])); len(fields) > 0 {
+					ki.Version = fields[0]
+				}
+			}
+		}
+		if ki.Version == "" {
+			return nil, errors.New("no kernel version in boot protocol header")
+		}
+		return ki, nil
+	}
+
+	// EFI zboot image with compressed payload
+	if len(buf) > 56 && string(buf[0:2]) == "MZ" && string(buf[4:8]) == "zimg" {
+		offset := int(binary.LittleEndian.Uint32(buf[8:]))
+		size := int(binary.LittleEndian.Uint32(buf[12:]))
+		comp := string(bytes.TrimRight(buf[24:56], "\x00"))
+		if offset+size > len(buf) {
+			return nil, errors.New("truncated zboot payload")
+		}
+
+		payload, err := decompress(comp, bytes.NewReader(buf[offset:offset+size]))
+		if err != nil {
+			return nil, fmt.Errorf("cannot decompress %s zboot payload: %w", comp, err)
+		}
+		return detectKernel(payload, depth+1)
+	}
+
+	// raw compressed kernel
+	for comp, magic := range map[string][]byte{"gzip": gzipMagic, "zstd": zstdMagic, "xz": xzMagic} {
+		if bytes.HasPrefix(buf, magic) {
+			payload, err := decompress(comp, bytes.NewReader(buf))
+			if err != nil {
+				return nil, fmt.Errorf("cannot decompress %s kernel: %w", comp, err)
+			}
+			return detectKernel(payload, depth+1)
+		}
+	}
+
+	ki := &KernelInfo{}
+	if len(buf) > 0x40 && string(buf[0x38:0x3c]) == "ARM\x64" {
+		ki.Architecture = "aarch64"
+	} else if pf, err := pe.NewFile(bytes.NewReader(buf)); err == nil && bytes.HasPrefix(buf, []byte("MZ")) {
+		// debug/pe also parses files without DOS header
+		switch pf.Machine {
+		case pe.IMAGE_FILE_MACHINE_AMD64:
+			ki.Architecture = "x86_64"
+		case pe.IMAGE_FILE_MACHINE_ARM64:
+			ki.Architecture = "aarch64"
+		default:
+			return nil, fmt.Errorf("unsupported PE machine type 0x%x", pf.Machine)
+		}
+	} else if ef, err := elf.NewFile(bytes.NewReader(buf)); err == nil {
+		switch {
+		case ef.Machine == elf.EM_X86_64:
+			ki.Architecture = "x86_64"
+		case ef.Machine == elf.EM_AARCH64:
+			ki.Architecture = "aarch64"
+		case ef.Machine == elf.EM_PPC64 && ef.ByteOrder == binary.LittleEndian:
+			ki.Architecture = "ppc64le"
+		case ef.Machine == elf.EM_PPC64:
+			ki.Architecture = "ppc64"
+		default:
+			return nil, fmt.Errorf("unsupported ELF machine type %s", ef.Machine)
+		}
+	} else {
+		return nil, errors.New("unknown kernel image format")
+	}
+
+	m := linuxVersionRegexp.FindSubmatch(buf)
+	if m == nil {
+		return nil, errors.New("no kernel version string found")
+	}
+	ki.Version = string(m[1])
+
+	return ki, nil
+}
+
+func decompress(comp string, r io.Reader) ([]byte, error) {
+	var dr io.Reader
+	switch comp {
+	case "gzip":
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		dr = gr
+	case "zstd", "zstd22":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		dr = zr
+	case "xz":
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		dr = xr
+	default:
+		return nil, fmt.Errorf("unsupported compression %s", comp)
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(dr, maxDecompressedKernel+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) > maxDecompressedKernel {
+		return nil, fmt.Errorf("decompressed payload exceeds %d bytes", maxDecompressedKernel)
+	}
+	return buf, nil
+}
+
+// decompressReader returns reader decompressing the stream when it starts with known
+// compression magic, or nil if the stream is not compressed.
+func decompressReader(br *bufio.Reader) (io.Reader, error) {
+	magic, _ := br.Peek(6)
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case bytes.HasPrefix(magic, xzMagic):
+		return xz.NewReader(br)
+	}
+
+	return nil, nil
+}
+
+// DetectInitrdOSRelease reads os-release from initrd which consists of one or more
+// concatenated cpio archives, optionally compressed.
+func DetectInitrdOSRelease(filename string) (*OSRelease, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	files := make(map[string][]byte)
+	links := make(map[string]string)
+	br := bufio.NewReader(f)
+	for {
+		// archives are padded with zeroes
+		for {
+			b, err := br.Peek(1)
+			if err != nil || b[0] != 0 {
+				break
+			}
+			_, _ = br.Discard(1)
+		}
+
+		magic, err := br.Peek(6)
+		if errors.Is(err, io.EOF) && len(magic) == 0 {
+			break
+		}
+
+		if IsCpioMagic(magic) {
+			err = readReleaseFiles(NewCpioReader(br), files, links)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		dr, err := decompressReader(br)
+		if err != nil {
+			return nil, err
+		}
+		if dr == nil {
+			return nil, fmt.Errorf("unknown initrd format at magic %x", magic)
+		}
+		br = bufio.NewReader(dr)
+	}
+
+	name := "etc/os-release"
+	for i := 0; i < 10; i++ {
+		if target, ok := links[name]; ok {
+			if strings.HasPrefix(target, "/") {
+				name = cpioName(target)
+			} else {
+				name = path.Join(path.Dir(name), target)
+			}
+			continue
+		}
+
+		if data, ok := files[name]; ok {
+			return parseOSRelease(data)
+		}
+
+		if name == "etc/os-release" {
+			name = "usr/lib/os-release"
+			continue
+		}
+		break
+	}
+
+	return nil, errors.New("no os-release found in initrd")
+}
+
+var releaseFileRegexp = regexp.MustCompile(`^(etc|usr/lib)/[a-z-]*release$`)
+
+func readReleaseFiles(cr *CpioReader, files map[string][]byte, links map[string]string) error {
+	for {
+		h, err := cr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		name := cpioName(h.Name)
+		if !releaseFileRegexp.MatchString(name) || h.Size > 64*1024 {
+			continue
+		}
+
+		data, err := io.ReadAll(cr)
+		if err != nil {
+			return err
+		}
+		if h.IsSymlink() {
+			links[name] = string(data)
+		} else if h.IsRegular() {
+			files[name] = data
+		}
+	}
+}
+
+func parseOSRelease(data []byte) (*OSRelease, error) {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		values[k] = strings.Trim(v, `"'`)
+	}
+
+	osr := &OSRelease{
+		Name:    strings.ToLower(values["ID"]),
+		Version: strings.ToLower(values["VERSION_ID"]),
+	}
+	if osr.Name == "" || osr.Version == "" {
+		return nil, errors.New("os-release is missing ID or VERSION_ID")
+	}
+
+	return osr, nil
+}
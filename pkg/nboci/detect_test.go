@@ -0,0 +1,156 @@
+package nboci
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testBzImage returns an x86 boot protocol header with a kernel version string.
+func testBzImage(version string, xloadflags uint16) []byte {
+	buf := make([]byte, 0x400)
+	copy(buf[0x202:], "HdrS")
+	binary.LittleEndian.PutUint16(buf[0x236:], xloadflags)
+	if version != "" {
+		binary.LittleEndian.PutUint16(buf[0x20e:], 0x100)
+		copy(buf[0x300:], version+" (mockbuild@example.com) #1 SMP\x00")
+	}
+	return buf
+}
+
+// testArm64Image returns an ARM64 Image header followed by the Linux banner.
+func testArm64Image(version string) []byte {
+	buf := make([]byte, 0x100)
+	copy(buf[0x38:], "ARM\x64")
+	return append(buf, []byte("Linux version "+version+" (gcc) #1 SMP\x00")...)
+}
+
+func testGzip(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testZboot returns an EFI zboot image with a compressed payload.
+func testZboot(comp string, payload []byte, size int) []byte {
+	buf := make([]byte, 0x80)
+	copy(buf, "MZ")
+	copy(buf[4:], "zimg")
+	binary.LittleEndian.PutUint32(buf[8:], 0x80)
+	binary.LittleEndian.PutUint32(buf[12:], uint32(size))
+	copy(buf[24:], comp)
+	return append(buf, payload...)
+}
+
+func TestDetectKernel(t *testing.T) {
+	arm := testArm64Image("6.9.0-1.fc41.aarch64")
+	armGzip := testGzip(t, arm)
+
+	tests := []struct {
+		name    string
+		image   []byte
+		version string
+		arch    string
+		err     string
+	}{
+		{name: "bzImage", image: testBzImage("6.8.5-301.fc40.x86_64", 1), version: "6.8.5-301.fc40.x86_64", arch: "x86_64"},
+		{name: "bzImage 32-bit", image: testBzImage("6.8.5", 0), err: "32-bit"},
+		{name: "bzImage without version", image: testBzImage("", 1), err: "no kernel version"},
+		{name: "arm64 Image", image: arm, version: "6.9.0-1.fc41.aarch64", arch: "aarch64"},
+		{name: "gzip Image", image: armGzip, version: "6.9.0-1.fc41.aarch64", arch: "aarch64"},
+		{name: "zboot", image: testZboot("gzip", armGzip, len(armGzip)), version: "6.9.0-1.fc41.aarch64", arch: "aarch64"},
+		{name: "zboot truncated", image: testZboot("gzip", armGzip, len(armGzip)+1), err: "truncated zboot payload"},
+		{name: "zboot unknown compression", image: testZboot("lz4", armGzip, len(armGzip)), err: "unsupported compression lz4"},
+		{name: "unknown", image: make([]byte, 0x400), err: "unknown kernel image format"},
+		{name: "no banner", image: testArm64Image("")[:0x100], err: "no kernel version string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ki, err := detectKernel(tt.image, 0)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ki.Version != tt.version || ki.Architecture != tt.arch {
+				t.Fatalf("detected %s %s, expected %s %s", ki.Version, ki.Architecture, tt.version, tt.arch)
+			}
+		})
+	}
+}
+
+func TestDetectKernelLimit(t *testing.T) {
+	image := testGzip(t, testArm64Image("6.9.0"))
+	limit := maxDecompressedKernel
+	maxDecompressedKernel = 64
+	t.Cleanup(func() { maxDecompressedKernel = limit })
+
+	_, err := detectKernel(image, 0)
+	if err == nil || !strings.Contains(err.Error(), "exceeds 64 bytes") {
+		t.Fatalf("expected size limit error, got %v", err)
+	}
+}
+
+func TestDetectInitrdOSRelease(t *testing.T) {
+	// uncompressed early cpio followed by compressed main archive like dracut builds them
+	early := writeTestCpio(testCpioEntry{name: "kernel/x86/microcode/GenuineIntel.bin", mode: cpioModeRegular, data: "ucode"})
+	main := writeTestCpio(
+		testCpioEntry{name: "etc/os-release", mode: cpioModeSymlink | 0777, data: "../usr/lib/os-release"},
+		testCpioEntry{name: "usr/lib/os-release", mode: cpioModeRegular | 0644, data: "NAME=\"Fedora Linux\"\nID=fedora\nVERSION_ID=41\n"},
+	)
+	filename := filepath.Join(t.TempDir(), "initrd.img")
+	if err := os.WriteFile(filename, append(append(early, make([]byte, 512)...), testGzip(t, main)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	osr, err := DetectInitrdOSRelease(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if osr.Name != "fedora" || osr.Version != "41" {
+		t.Fatalf("detected %s %s", osr.Name, osr.Version)
+	}
+}
+
+func TestParseOSRelease(t *testing.T) {
+	tests := []struct {
+		data    string
+		name    string
+		version string
+	}{
+		{"ID=fedora\nVERSION_ID=41\n", "fedora", "41"},
+		{"# comment\nID=\"rhel\"\nVERSION_ID='9.4'\n", "rhel", "9.4"},
+		{"ID=Debian\nVERSION_ID=\"12\"", "debian", "12"},
+		{"ID=arch\n", "", ""},
+	}
+	for _, tt := range tests {
+		osr, err := parseOSRelease([]byte(tt.data))
+		if tt.name == "" {
+			if err == nil {
+				t.Errorf("expected error for %q", tt.data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if osr.Name != tt.name || osr.Version != tt.version {
+			t.Errorf("parsed %s %s from %q", osr.Name, osr.Version, tt.data)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package nboci
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+)
+
+type DHCPConfigArgs struct {
+	Format       string `arg:"-f,--format,required" help:"output format (dnsmasq, isc, kea)" placeholder:"FORMAT"`
+	Name         string `arg:"-n,--osname,required" help:"distribution name (e.g. fedora, debian)"`
+	Version      string `arg:"-v,--osversion,required" help:"distribution version (e.g. 45, 9.6)"`
+	Architecture string `arg:"-a,--osarch,required" help:"architecture (e.g. x86_64, aarch64)"`
+	Prefix       string `arg:"-p,--prefix" help:"path of the pulled trees on the TFTP server (default: TFTP root)"`
+	Server       string `arg:"-s,--server" help:"TFTP server IPv4 address (next-server)"`
+	Server6      string `arg:"-S,--server6" help:"TFTP server IPv6 address (required for DHCPv6 TFTP entries)"`
+	HTTPURL      string `arg:"-u,--http-url" help:"base URL of the pulled trees on HTTP server (required for UEFI HTTP entries)" placeholder:"URL"`
+	Alt          bool   `arg:"--alt" help:"hand out alternative entry point to UEFI clients (e.g. SecureBoot off)"`
+}
+
+// dhcpBoot is a single client class mapping client architecture types to a boot file.
+type dhcpBoot struct {
+	class string
+	archs []uint16
+	http  bool
+	v6    bool
+	file  string
+}
+
+// dhcpArch describes client architecture types (DHCPv4 option 93, DHCPv6 option 61)
+// of a given boot method as assigned by IANA.
+type dhcpArch struct {
+	kind   string
+	archs  []uint16
+	http   bool
+	legacy bool
+}
+
+var dhcpArchTypes = map[string][]dhcpArch{
+	"x86_64": {
+		{kind: "bios", archs: []uint16{0}, legacy: true},
+		{kind: "efi", archs: []uint16{7, 9}},
+		{kind: "efi-http", archs: []uint16{16}, http: true},
+	},
+	"aarch64": {
+		{kind: "efi", archs: []uint16{11}},
+		{kind: "efi-http", archs: []uint16{19}, http: true},
+	},
+	"ppc64le": {
+		{kind: "ofw", archs: []uint16{12, 14}},
+	},
+	"ppc64": {
+		{kind: "ofw", archs: []uint16{12}},
+	},
+}
+
+// HTTPClientVendorClass is the vendor class identifier of UEFI HTTP boot clients.
+const HTTPClientVendorClass = "HTTPClient"
+
+func DHCPConfig(args DHCPConfigArgs) {
+	if !AlphanumRegexp.MatchString(args.Name) {
+		Fatal("invalid character in name")
+	}
+	if !AlphanumRegexp.MatchString(args.Version) {
+		Fatal("invalid character in version")
+	}
+	if !ArchRegexp.MatchString(args.Architecture) {
+		Fatal("unknown architecture")
+	}
+	if args.Server != "" && net.ParseIP(args.Server).To4() == nil {
+		Fatal("invalid IPv4 server address", args.Server)
+	}
+	if args.Server6 != "" && (net.ParseIP(args.Server6) == nil || net.ParseIP(args.Server6).To4() != nil) {
+		Fatal("invalid IPv6 server address", args.Server6)
+	}
+
+	boots := dhcpBoots(args)
+
+	var out string
+	var err error
+	switch args.Format {
+	case "dnsmasq":
+		out = dnsmasqConfig(args, boots)
+	case "isc":
+		out = iscConfig(args, boots)
+	case "kea":
+		out, err = keaConfig(args, boots)
+	default:
+		Fatal("unknown format", args.Format)
+	}
+	if err != nil {
+		FatalErr(err, "cannot generate configuration")
+	}
+
+	fmt.Print(out)
+}
+
+func dhcpBoots(args DHCPConfigArgs) []dhcpBoot {
+	tree := path.Join(args.Name, args.Version, args.Architecture)
+	result := make([]dhcpBoot, 0)
+
+	for _, v6 := range []bool{false, true} {
+		for _, da := range dhcpArchTypes[args.Architecture] {
+			link := "boot"
+			if da.legacy {
+				// there is no BIOS network boot over DHCPv6
+				if v6 {
+					continue
+				}
+				link = "boot-legacy"
+			} else if args.Alt {
+				link = "boot-alt"
+			}
+
+			file := path.Join(args.Prefix, tree, link)
+			if da.http {
+				if args.HTTPURL == "" {
+					continue
+				}
+				// the URL is the base of the trees, the TFTP prefix does not apply
+				file = strings.TrimSuffix(args.HTTPURL, "/") + "/" + path.Join(tree, link)
+			} else if v6 {
+				if args.Server6 == "" {
+					continue
+				}
+				file = fmt.Sprintf("tftp://[%s]/%s", args.Server6, strings.TrimPrefix(file, "/"))
+			}
+
+			class := fmt.Sprintf("nboci-%s-%s", da.kind, args.Architecture)
+			if v6 {
+				class = fmt.Sprintf("nboci-%s6-%s", da.kind, args.Architecture)
+			}
+
+			result = append(result, dhcpBoot{
+				class: class,
+				archs: da.archs,
+				http:  da.http,
+				v6:    v6,
+				file:  file,
+			})
+		}
+	}
+
+	return result
+}
+
+func dhcpHeader(args DHCPConfigArgs, comment string) string {
+	return fmt.Sprintf("%s generated by nboci for %s %s %s\n", comment, args.Name, args.Version, args.Architecture)
+}
+
+// vendorClass6 returns DHCPv6 vendor class option data (enterprise number 343) as hex bytes.
+func vendorClass6() string {
+	data := []byte{0x00, 0x00, 0x01, 0x57, 0x00, byte(len(HTTPClientVendorClass))}
+	data = append(data, []byte(HTTPClientVendorClass)...)
+
+	hex := make([]string, 0, len(data))
+	for _, b := range data {
+		hex = append(hex, fmt.Sprintf("%02x", b))
+	}
+	return strings.Join(hex, ":")
+}
+
+func dnsmasqConfig(args DHCPConfigArgs, boots []dhcpBoot) string {
+	str := strings.Builder{}
+	str.WriteString(dhcpHeader(args, "#"))
+
+	for _, b := range boots {
+		str.WriteString("\n")
+		// UEFI HTTP clients must match both architecture and vendor class like in other formats
+		archTag := b.class
+		if b.http && !b.v6 {
+			archTag = b.class + "-arch"
+		}
+		for _, a := range b.archs {
+			if b.v6 {
+				fmt.Fprintf(&str, "dhcp-match=set:%s,option6:61,%d\n", archTag, a)
+			} else {
+				fmt.Fprintf(&str, "dhcp-match=set:%s,option:client-arch,%d\n", archTag, a)
+			}
+		}
+		if archTag != b.class {
+			fmt.Fprintf(&str, "dhcp-vendorclass=set:%s-vendor,%s\n", b.class, HTTPClientVendorClass)
+			fmt.Fprintf(&str, "tag-if=set:%s,tag:%s,tag:%s-vendor\n", b.class, archTag, b.class)
+		}
+
+		if b.v6 {
+			if b.http {
+				fmt.Fprintf(&str, "dhcp-option-force=tag:%s,option6:16,%s\n", b.class, vendorClass6())
+			}
+			fmt.Fprintf(&str, "dhcp-option=tag:%s,option6:bootfile-url,%s\n", b.class, b.file)
+			continue
+		}
+
+		if b.http {
+			fmt.Fprintf(&str, "dhcp-option-force=tag:%s,option:vendor-class,%s\n", b.class, HTTPClientVendorClass)
+			fmt.Fprintf(&str, "dhcp-boot=tag:%s,%s\n", b.class, b.file)
+		} else if args.Server != "" {
+			fmt.Fprintf(&str, "dhcp-boot=tag:%s,%s,,%s\n", b.class, b.file, args.Server)
+		} else {
+			fmt.Fprintf(&str, "dhcp-boot=tag:%s,%s\n", b.class, b.file)
+		}
+	}
+
+	return str.String()
+}
+
+func iscMatch(option string, archs []uint16) string {
+	conds := make([]string, 0, len(archs))
+	for _, a := range archs {
+		conds = append(conds, fmt.Sprintf("option %s = %02x:%02x", option, a>>8, a&0xff))
+	}
+	return strings.Join(conds, " or ")
+}
+
+func iscConfig(args DHCPConfigArgs, boots []dhcpBoot) string {
+	v4 := strings.Builder{}
+	v6 := strings.Builder{}
+
+	v4.WriteString(dhcpHeader(args, "#"))
+	v4.WriteString("# dhcpd.conf (DHCPv4)\n")
+	v4.WriteString("option architecture-type code 93 = unsigned integer 16;\n")
+	v6.WriteString("\n# dhcpd6.conf (DHCPv6)\n")
+	v6.WriteString("option dhcp6.bootfile-url code 59 = string;\n")
+	v6.WriteString("option dhcp6.client-arch-type code 61 = array of unsigned integer 16;\n")
+	v6.WriteString("option dhcp6.vendor-class code 16 = {integer 32, integer 16, string};\n")
+
+	has6 := false
+	for _, b := range boots {
+		if b.v6 {
+			has6 = true
+			fmt.Fprintf(&v6, "\nclass \"%s\" {\n", b.class)
+			fmt.Fprintf(&v6, "  match if %s;\n", iscMatch("dhcp6.client-arch-type", b.archs))
+			if b.http {
+				fmt.Fprintf(&v6, "  option dhcp6.vendor-class 343 %d \"%s\";\n", len(HTTPClientVendorClass), HTTPClientVendorClass)
+			}
+			fmt.Fprintf(&v6, "  option dhcp6.bootfile-url \"%s\";\n", b.file)
+			v6.WriteString("}\n")
+			continue
+		}
+
+		fmt.Fprintf(&v4, "\nclass \"%s\" {\n", b.class)
+		if b.http {
+			fmt.Fprintf(&v4, "  match if (%s) and substring (option vendor-class-identifier, 0, %d) = \"%s\";\n",
+				iscMatch("architecture-type", b.archs), len(HTTPClientVendorClass), HTTPClientVendorClass)
+			fmt.Fprintf(&v4, "  option vendor-class-identifier \"%s\";\n", HTTPClientVendorClass)
+		} else {
+			fmt.Fprintf(&v4, "  match if %s;\n", iscMatch("architecture-type", b.archs))
+			if args.Server != "" {
+				fmt.Fprintf(&v4, "  next-server %s;\n", args.Server)
+			}
+		}
+		fmt.Fprintf(&v4, "  filename \"%s\";\n", b.file)
+		v4.WriteString("}\n")
+	}
+
+	if has6 {
+		v4.WriteString(v6.String())
+	}
+	return v4.String()
+}
+
+type keaOptionData struct {
+	Name string `json:"name"`
+	Data string `json:"data"`
+}
+
+type keaClientClass struct {
+	Name         string          `json:"name"`
+	Comment      string          `json:"comment,omitempty"`
+	Test         string          `json:"test"`
+	NextServer   string          `json:"next-server,omitempty"`
+	BootFileName string          `json:"boot-file-name,omitempty"`
+	OptionData   []keaOptionData `json:"option-data,omitempty"`
+}
+
+type keaDaemon struct {
+	ClientClasses []keaClientClass `json:"client-classes"`
+}
+
+type keaConfigRoot struct {
+	Dhcp4 *keaDaemon `json:"Dhcp4,omitempty"`
+	Dhcp6 *keaDaemon `json:"Dhcp6,omitempty"`
+}
+
+func keaTest(option int, archs []uint16) string {
+	conds := make([]string, 0, len(archs))
+	for _, a := range archs {
+		conds = append(conds, fmt.Sprintf("option[%d].hex == 0x%04x", option, a))
+	}
+	if len(conds) == 1 {
+		return conds[0]
+	}
+	return "(" + strings.Join(conds, " or ") + ")"
+}
+
+func keaConfig(args DHCPConfigArgs, boots []dhcpBoot) (string, error) {
+	root := keaConfigRoot{}
+	comment := strings.TrimSpace(dhcpHeader(args, ""))
+
+	for _, b := range boots {
+		if b.v6 {
+			if root.Dhcp6 == nil {
+				root.Dhcp6 = &keaDaemon{}
+			}
+			cc := keaClientClass{
+				Name:    b.class,
+				Comment: comment,
+				Test:    keaTest(61, b.archs),
+			}
+			if b.http {
+				cc.OptionData = append(cc.OptionData, keaOptionData{Name: "vendor-class", Data: "343, " + HTTPClientVendorClass})
+			}
+			cc.OptionData = append(cc.OptionData, keaOptionData{Name: "bootfile-url", Data: b.file})
+			root.Dhcp6.ClientClasses = append(root.Dhcp6.ClientClasses, cc)
+			continue
+		}
+
+		if root.Dhcp4 == nil {
+			root.Dhcp4 = &keaDaemon{}
+		}
+		cc := keaClientClass{
+			Name:         b.class,
+			Comment:      comment,
+			Test:         keaTest(93, b.archs),
+			BootFileName: b.file,
+		}
+		if b.http {
+			cc.Test = fmt.Sprintf("%s and substring(option[60].hex,0,%d) == '%s'", cc.Test, len(HTTPClientVendorClass), HTTPClientVendorClass)
+			cc.OptionData = append(cc.OptionData, keaOptionData{Name: "vendor-class-identifier", Data: HTTPClientVendorClass})
+		} else {
+			cc.NextServer = args.Server
+		}
+		root.Dhcp4.ClientClasses = append(root.Dhcp4.ClientClasses, cc)
+	}
+
+	buf, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(buf) + "\n", nil
+}
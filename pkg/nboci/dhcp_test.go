@@ -0,0 +1,120 @@
+package nboci
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// checkGolden compares output with a file in testdata, the file is rewritten with -update.
+func checkGolden(t *testing.T, name, output string) {
+	t.Helper()
+	filename := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(output), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != string(expected) {
+		t.Errorf("output differs from %s:\n%s", filename, output)
+	}
+}
+
+func TestDHCPConfig(t *testing.T) {
+	full := DHCPConfigArgs{
+		Name:         "fedora",
+		Version:      "41",
+		Architecture: "x86_64",
+		Prefix:       "/nboci",
+		Server:       "192.0.2.1",
+		Server6:      "2001:db8::1",
+		HTTPURL:      "http://boot.example.com/nboci/",
+	}
+	tftp := DHCPConfigArgs{
+		Name:         "fedora",
+		Version:      "41",
+		Architecture: "aarch64",
+		Alt:          true,
+	}
+
+	tests := []struct {
+		name string
+		args DHCPConfigArgs
+	}{
+		{"x86_64", full},
+		{"aarch64-alt", tftp},
+	}
+	for _, tt := range tests {
+		boots := dhcpBoots(tt.args)
+		t.Run(tt.name+"/dnsmasq", func(t *testing.T) {
+			checkGolden(t, "dhcp-"+tt.name+".dnsmasq", dnsmasqConfig(tt.args, boots))
+		})
+		t.Run(tt.name+"/isc", func(t *testing.T) {
+			checkGolden(t, "dhcp-"+tt.name+".isc", iscConfig(tt.args, boots))
+		})
+		t.Run(tt.name+"/kea", func(t *testing.T) {
+			out, err := keaConfig(tt.args, boots)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, "dhcp-"+tt.name+".kea", out)
+		})
+	}
+}
+
+func TestDHCPBoots(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    DHCPConfigArgs
+		classes []string
+	}{
+		{
+			name:    "bios and efi over tftp",
+			args:    DHCPConfigArgs{Name: "fedora", Version: "41", Architecture: "x86_64"},
+			classes: []string{"nboci-bios-x86_64", "nboci-efi-x86_64"},
+		},
+		{
+			name:    "http needs url",
+			args:    DHCPConfigArgs{Name: "fedora", Version: "41", Architecture: "x86_64", HTTPURL: "http://h/"},
+			classes: []string{"nboci-bios-x86_64", "nboci-efi-x86_64", "nboci-efi-http-x86_64", "nboci-efi-http6-x86_64"},
+		},
+		{
+			name:    "dhcpv6 tftp needs server",
+			args:    DHCPConfigArgs{Name: "fedora", Version: "41", Architecture: "aarch64", Server6: "2001:db8::1"},
+			classes: []string{"nboci-efi-aarch64", "nboci-efi6-aarch64"},
+		},
+		{
+			name:    "open firmware",
+			args:    DHCPConfigArgs{Name: "fedora", Version: "41", Architecture: "ppc64le"},
+			classes: []string{"nboci-ofw-ppc64le"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			boots := dhcpBoots(tt.args)
+			classes := make([]string, 0, len(boots))
+			for _, b := range boots {
+				classes = append(classes, b.class)
+			}
+			if len(classes) != len(tt.classes) {
+				t.Fatalf("classes %v, expected %v", classes, tt.classes)
+			}
+			for i := range classes {
+				if classes[i] != tt.classes[i] {
+					t.Fatalf("classes %v, expected %v", classes, tt.classes)
+				}
+			}
+		})
+	}
+}
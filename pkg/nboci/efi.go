@@ -0,0 +1,392 @@
+package nboci
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/digitorus/pkcs7"
+)
+
+// loadSecureBootCAs reads PEM or DER certificates trusted by firmware (e.g. Microsoft UEFI CA
+// or a db certificate of an internal CA), or returns nil when there are no files.
+func loadSecureBootCAs(files []string) (*x509.CertPool, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	pool := x509.NewCertPool()
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		certs, err := parseCertificates(data)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse %s: %w", f, err)
+		}
+		for _, c := range certs {
+			pool.AddCert(c)
+		}
+	}
+
+	return pool, nil
+}
+
+// parseCertificates parses all certificates of PEM data or DER encoded certificates.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var block *pem.Block
+	certs := make([]*x509.Certificate, 0)
+	for rest := data; ; {
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) > 0 {
+		return certs, nil
+	}
+
+	certs, err := x509.ParseCertificates(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates")
+	}
+	return certs, nil
+}
+
+// Authenticode object identifiers.
+var (
+	oidSpcPEImageData = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 15}
+	oidDigestSHA1     = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidDigestSHA256   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidDigestSHA384   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidDigestSHA512   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+// winCertTypePKCSSignedData is the WIN_CERTIFICATE type of Authenticode signatures.
+const winCertTypePKCSSignedData = 2
+
+// spcAttributeTypeAndOptionalValue is the first element of SpcIndirectDataContent.
+type spcAttributeTypeAndOptionalValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"optional"`
+}
+
+// digestInfo is the image digest of SpcIndirectDataContent.
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+// authenticodeSignature is a PKCS #7 signature of a PE image digest.
+type authenticodeSignature struct {
+	p7     *pkcs7.PKCS7
+	signer *x509.Certificate
+}
+
+// peImage is a PE file with offsets of fields excluded from the Authenticode image digest and
+// file ranges of sections sorted by file offset.
+type peImage struct {
+	data        []byte
+	checksum    int
+	certDir     int
+	certOffset  int
+	certSize    int
+	headersSize int
+	sections    []peSection
+}
+
+// peSection is the raw data range of a PE section.
+type peSection struct {
+	offset int
+	size   int
+}
+
+// parsePE parses headers of a PE file, nil is returned for files which are not PE images.
+func parsePE(data []byte) (*peImage, error) {
+	if len(data) < 0x40 || string(data[:2]) != "MZ" {
+		return nil, nil
+	}
+	pe := int(binary.LittleEndian.Uint32(data[0x3c:]))
+	if pe+24 > len(data) || string(data[pe:pe+4]) != "PE\x00\x00" {
+		return nil, nil
+	}
+
+	optSize := int(binary.LittleEndian.Uint16(data[pe+20:]))
+	opt := pe + 24
+	if opt+optSize > len(data) || optSize < 2 {
+		return nil, errors.New("truncated PE optional header")
+	}
+	var dirCount, dirs int
+	switch binary.LittleEndian.Uint16(data[opt:]) {
+	case 0x10b:
+		dirCount, dirs = opt+92, opt+96
+	case 0x20b:
+		dirCount, dirs = opt+108, opt+112
+	default:
+		return nil, errors.New("unknown PE optional header")
+	}
+	certDir := dirs + 4*8
+	if dirCount+4 > opt+optSize {
+		return nil, errors.New("truncated PE optional header")
+	}
+	if binary.LittleEndian.Uint32(data[dirCount:]) <= 4 || certDir+8 > opt+optSize {
+		// no certificate table, the image is not signed
+		return &peImage{data: data}, nil
+	}
+
+	img := &peImage{
+		data:        data,
+		checksum:    opt + 64,
+		certDir:     certDir,
+		certOffset:  int(binary.LittleEndian.Uint32(data[certDir:])),
+		certSize:    int(binary.LittleEndian.Uint32(data[certDir+4:])),
+		headersSize: int(binary.LittleEndian.Uint32(data[opt+60:])),
+	}
+	if img.certSize > 0 && (img.certOffset < certDir+8 || img.certOffset+img.certSize > len(data)) {
+		return nil, errors.New("invalid PE certificate table")
+	}
+	if img.headersSize < certDir+8 || img.headersSize > len(data) {
+		return nil, errors.New("invalid PE headers size")
+	}
+
+	count := int(binary.LittleEndian.Uint16(data[pe+6:]))
+	table := opt + optSize
+	if table+count*40 > img.headersSize {
+		return nil, errors.New("truncated PE section table")
+	}
+	for i := 0; i < count; i++ {
+		sec := peSection{
+			size:   int(binary.LittleEndian.Uint32(data[table+i*40+16:])),
+			offset: int(binary.LittleEndian.Uint32(data[table+i*40+20:])),
+		}
+		if sec.size == 0 {
+			continue
+		}
+		if sec.offset < img.headersSize || sec.offset+sec.size > len(data) {
+			return nil, errors.New("invalid PE section")
+		}
+		img.sections = append(img.sections, sec)
+	}
+	slices.SortFunc(img.sections, func(a, b peSection) int { return a.offset - b.offset })
+
+	return img, nil
+}
+
+// digest returns the Authenticode image digest. It covers headers except checksum and the
+// certificate table directory entry, raw data of sections in file order and data after the
+// sections except the certificate table. Bytes in gaps between sections are not covered.
+func (img *peImage) digest(hash crypto.Hash) ([]byte, error) {
+	h := hash.New()
+	h.Write(img.data[:img.checksum])
+	h.Write(img.data[img.checksum+4 : img.certDir])
+	h.Write(img.data[img.certDir+8 : img.headersSize])
+
+	hashed := img.headersSize
+	for _, sec := range img.sections {
+		h.Write(img.data[sec.offset : sec.offset+sec.size])
+		hashed += sec.size
+	}
+
+	end := len(img.data) - img.certSize
+	if hashed > end {
+		return nil, errors.New("PE sections overlap the certificate table")
+	}
+	h.Write(img.data[hashed:end])
+
+	return h.Sum(nil), nil
+}
+
+// signatures returns Authenticode signatures of the image after verifying their image digests
+// and signatures, certificate chains are not verified.
+func (img *peImage) signatures() ([]authenticodeSignature, error) {
+	result := make([]authenticodeSignature, 0, 1)
+	table := img.data[img.certOffset : img.certOffset+img.certSize]
+	for len(table) >= 8 {
+		length := int(binary.LittleEndian.Uint32(table))
+		if length < 8 || length > len(table) {
+			return nil, errors.New("invalid PE certificate entry")
+		}
+		if binary.LittleEndian.Uint16(table[6:]) == winCertTypePKCSSignedData {
+			sig, err := img.verifySignature(table[8:length])
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, *sig)
+		}
+		table = table[min((length+7)/8*8, len(table)):]
+	}
+
+	return result, nil
+}
+
+// verifySignature parses a PKCS #7 signature and verifies it signs digest of the image.
+func (img *peImage) verifySignature(der []byte) (*authenticodeSignature, error) {
+	// certificate entries are padded to 8 bytes
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &raw); err != nil {
+		return nil, err
+	}
+	p7, err := pkcs7.Parse(raw.FullBytes)
+	if err != nil {
+		return nil, err
+	}
+	signer := p7.GetOnlySigner()
+	if signer == nil {
+		return nil, errors.New("authenticode signature must have a single signer")
+	}
+	if err := p7.Verify(); err != nil {
+		return nil, err
+	}
+
+	// content is SpcIndirectDataContent without the sequence header
+	var data spcAttributeTypeAndOptionalValue
+	rest, err := asn1.Unmarshal(p7.Content, &data)
+	if err != nil {
+		return nil, err
+	}
+	if !data.Type.Equal(oidSpcPEImageData) {
+		return nil, errors.New("not a PE image signature")
+	}
+	var di digestInfo
+	if _, err := asn1.Unmarshal(rest, &di); err != nil {
+		return nil, err
+	}
+
+	var hash crypto.Hash
+	switch {
+	case di.Algorithm.Algorithm.Equal(oidDigestSHA1):
+		hash = crypto.SHA1
+	case di.Algorithm.Algorithm.Equal(oidDigestSHA256):
+		hash = crypto.SHA256
+	case di.Algorithm.Algorithm.Equal(oidDigestSHA384):
+		hash = crypto.SHA384
+	case di.Algorithm.Algorithm.Equal(oidDigestSHA512):
+		hash = crypto.SHA512
+	default:
+		return nil, fmt.Errorf("unsupported digest algorithm %s", di.Algorithm.Algorithm)
+	}
+	digest, err := img.digest(hash)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(digest, di.Digest) {
+		return nil, errors.New("image digest does not match signature")
+	}
+
+	return &authenticodeSignature{p7: p7, signer: signer}, nil
+}
+
+// isPEFile reads DOS and PE signatures of a file without reading the rest of it, so large files
+// like initrds are not loaded into memory.
+func isPEFile(filename string) (bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 0x40)
+	if _, err := io.ReadFull(f, header); err != nil || string(header[:2]) != "MZ" {
+		return false, nil
+	}
+	sig := make([]byte, 4)
+	if _, err := f.ReadAt(sig, int64(binary.LittleEndian.Uint32(header[0x3c:]))); err != nil {
+		return false, nil
+	}
+
+	return string(sig) == "PE\x00\x00", nil
+}
+
+// authenticodeSignatures returns verified Authenticode signatures of a PE file. Nil is returned
+// for files which are not PE images or are not signed.
+func authenticodeSignatures(filename string) ([]authenticodeSignature, error) {
+	if ok, err := isPEFile(filename); err != nil || !ok {
+		return nil, err
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	img, err := parsePE(data)
+	if err != nil || img == nil || img.certSize == 0 {
+		return nil, err
+	}
+
+	return img.signatures()
+}
+
+// verifySecureBoot returns subject of the signer of a PE file whose certificate chains to one of
+// trusted certificates. Like firmware, certificate validity period and key usage are ignored.
+func verifySecureBoot(filename string, roots *x509.CertPool) (string, error) {
+	sigs, err := authenticodeSignatures(filename)
+	if err != nil {
+		return "", err
+	}
+	if len(sigs) == 0 {
+		return "", errors.New("not a signed EFI image")
+	}
+
+	errs := make([]error, 0, len(sigs))
+	for _, sig := range sigs {
+		intermediates := x509.NewCertPool()
+		for _, c := range sig.p7.Certificates {
+			intermediates.AddCert(c)
+		}
+		_, err := sig.signer.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			CurrentTime:   sig.signer.NotBefore,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sig.signer.Subject, err))
+			continue
+		}
+		return sig.signer.Subject.String(), nil
+	}
+
+	return "", errors.Join(errs...)
+}
+
+// checkSecureBoot verifies the entrypoint file against Secure Boot certificates when they are
+// given and returns the verified signer. Failures are reported as warnings unless Secure Boot is
+// required.
+func checkSecureBoot(filename, name string, roots *x509.CertPool, require bool) (string, error) {
+	if roots == nil {
+		return "", nil
+	}
+
+	signer, err := verifySecureBoot(filename, roots)
+	if err != nil {
+		err = fmt.Errorf("entrypoint %s would not boot with Secure Boot: %w", name, err)
+		if require {
+			return "", err
+		}
+		ErrorErr(err, "warning")
+		return "", nil
+	}
+	Print("entrypoint", name, "signed by", signer)
+
+	return signer, nil
+}
@@ -0,0 +1,198 @@
+package nboci
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitorus/pkcs7"
+)
+
+// testPESection is a section of a generated PE image.
+type testPESection struct {
+	name string
+	data string
+}
+
+// buildTestPE returns an unsigned x86_64 PE32+ image with the sections, headers and section data
+// are aligned to 512 bytes.
+func buildTestPE(sections ...testPESection) []byte {
+	const (
+		align   = 0x200
+		pe      = 0x40
+		opt     = pe + 24
+		optSize = 112 + 16*8
+	)
+	alignUp := func(n int) int { return (n + align - 1) / align * align }
+	headers := alignUp(opt + optSize + 40*len(sections))
+
+	data := make([]byte, headers)
+	copy(data, "MZ")
+	binary.LittleEndian.PutUint32(data[0x3c:], pe)
+	copy(data[pe:], "PE\x00\x00")
+	binary.LittleEndian.PutUint16(data[pe+4:], 0x8664)
+	binary.LittleEndian.PutUint16(data[pe+6:], uint16(len(sections)))
+	binary.LittleEndian.PutUint16(data[pe+20:], optSize)
+	binary.LittleEndian.PutUint16(data[pe+22:], 0x2022)
+	binary.LittleEndian.PutUint16(data[opt:], 0x20b)
+	binary.LittleEndian.PutUint32(data[opt+32:], align)
+	binary.LittleEndian.PutUint32(data[opt+36:], align)
+	binary.LittleEndian.PutUint32(data[opt+60:], uint32(headers))
+	binary.LittleEndian.PutUint16(data[opt+68:], 10) // EFI application
+	binary.LittleEndian.PutUint32(data[opt+108:], 16)
+
+	for i, s := range sections {
+		raw := []byte(s.data)
+		raw = append(raw, make([]byte, alignUp(len(raw))-len(raw))...)
+		h := data[opt+optSize+i*40:]
+		copy(h, s.name)
+		binary.LittleEndian.PutUint32(h[8:], uint32(len(s.data)))
+		binary.LittleEndian.PutUint32(h[12:], uint32(len(data)))
+		binary.LittleEndian.PutUint32(h[16:], uint32(len(raw)))
+		binary.LittleEndian.PutUint32(h[20:], uint32(len(data)))
+		binary.LittleEndian.PutUint32(h[36:], 0x40000040) // initialized, readable
+		data = append(data, raw...)
+	}
+	binary.LittleEndian.PutUint32(data[opt+56:], uint32(len(data))) // size of image
+
+	return data
+}
+
+// signTestPE appends an Authenticode signature of the image made by the certificate and key.
+func signTestPE(t *testing.T, image []byte, cert *x509.Certificate, key *ecdsa.PrivateKey) []byte {
+	t.Helper()
+	img, err := parsePE(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := img.digest(crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// SpcIndirectDataContent without the sequence header
+	content, err := asn1.Marshal(spcAttributeTypeAndOptionalValue{
+		Type:  oidSpcPEImageData,
+		Value: asn1.RawValue{FullBytes: []byte{0x30, 0}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	di, err := asn1.Marshal(digestInfo{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidDigestSHA256, Parameters: asn1.NullRawValue},
+		Digest:    digest,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd, err := pkcs7.NewSignedData(append(content, di...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd.SetContentType(asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4})
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := sd.AddSigner(cert, key, pkcs7.SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	der, err := sd.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := make([]byte, 8, 8+len(der)+8)
+	binary.LittleEndian.PutUint32(entry, uint32(8+len(der)))
+	binary.LittleEndian.PutUint16(entry[4:], 0x0200)
+	binary.LittleEndian.PutUint16(entry[6:], winCertTypePKCSSignedData)
+	entry = append(entry, der...)
+	entry = append(entry, make([]byte, (8-len(entry)%8)%8)...)
+
+	signed := append([]byte{}, image...)
+	binary.LittleEndian.PutUint32(signed[img.certDir:], uint32(len(signed)))
+	binary.LittleEndian.PutUint32(signed[img.certDir+4:], uint32(len(entry)))
+	return append(signed, entry...)
+}
+
+// generateTestSigner returns a code signing certificate with a subject issued by the CA.
+func generateTestSigner(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "nboci test signer"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func TestVerifySecureBoot(t *testing.T) {
+	ca, caKey := generateTestCA(t)
+	other, _ := generateTestCA(t)
+	signer, signerKey := generateTestSigner(t, ca, caKey)
+	image := buildTestPE(testPESection{".text", "code"}, testPESection{".data", "data"})
+	signed := signTestPE(t, image, signer, signerKey)
+
+	tampered := append([]byte{}, signed...)
+	// raw data offset of the first section in the section table
+	text := binary.LittleEndian.Uint32(tampered[0x58+240+20:])
+	tampered[text] = 'C'
+
+	tests := []struct {
+		name  string
+		image []byte
+		ca    *x509.Certificate
+		err   string
+	}{
+		{"valid", signed, ca, ""},
+		{"tampered section", tampered, ca, "image digest does not match signature"},
+		{"wrong CA", signed, other, "certificate signed by unknown authority"},
+		{"unsigned", image, ca, "not a signed EFI image"},
+		{"not PE", []byte(strings.Repeat("x", 512)), ca, "not a signed EFI image"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roots, err := loadSecureBootCAs([]string{writeTestFile(t, "ca.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tt.ca.Raw}))})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			subject, err := verifySecureBoot(writeTestFile(t, "shim.efi", tt.image), roots)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if subject != "CN=nboci test signer" {
+				t.Fatalf("unexpected signer %s", subject)
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+package nboci
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+const (
+	dockerManifestMediaType     = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerManifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// ociArchitectures maps architecture names to OCI platform architectures.
+var ociArchitectures = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+	"ppc64le": "ppc64le",
+	"ppc64":   "ppc64",
+}
+
+func ociArchitecture(arch string) string {
+	return ociArchitectures[arch]
+}
+
+func netbootArchitecture(ociArch string) string {
+	for k, v := range ociArchitectures {
+		if v == ociArch {
+			return k
+		}
+	}
+	return ""
+}
+
+// imageFile is a boot file found in a container image layer.
+type imageFile struct {
+	role    string
+	target  string
+	version string
+}
+
+var (
+	imageKernelRegexp = regexp.MustCompile(`^usr/lib/modules/([^/]+)/vmlinuz$`)
+	imageInitrdRegexp = regexp.MustCompile(`^usr/lib/modules/([^/]+)/initramfs\.img$`)
+	imageEFIRegexp    = regexp.MustCompile(`^usr/lib/bootupd/updates/EFI/[^/]+/((shim|grub|mm)(x64|aa64)\.efi)$`)
+)
+
+// imageFileFor returns role and target name of a file in container image or nil.
+func imageFileFor(name string) *imageFile {
+	if m := imageKernelRegexp.FindStringSubmatch(name); m != nil {
+		return &imageFile{role: "kernel", target: "vmlinuz", version: m[1]}
+	}
+	if m := imageInitrdRegexp.FindStringSubmatch(name); m != nil {
+		return &imageFile{role: "initrd", target: "initrd.img", version: m[1]}
+	}
+	if m := imageEFIRegexp.FindStringSubmatch(name); m != nil {
+		switch m[2] {
+		case "shim":
+			return &imageFile{role: "shim", target: "shim.efi"}
+		case "grub":
+			return &imageFile{role: "grub", target: m[1]}
+		default:
+			return &imageFile{role: m[1], target: m[1]}
+		}
+	}
+	if name == "usr/lib/os-release" || name == "etc/os-release" {
+		return &imageFile{role: name, target: strings.ReplaceAll(name, "/", "_")}
+	}
+
+	return nil
+}
+
+// imageExtractor tracks boot files across container image layers, files from upper
+// layers replace files from lower layers and whiteouts remove them. Kernels and initrds are
+// tracked per kernel version as kernel/VERSION and initrd/VERSION roles.
+type imageExtractor struct {
+	dir     string
+	files   map[string]string
+	targets map[string]string
+	sources map[string]string
+	// roles extracted from the current layer, whiteouts only apply to lower layers
+	current map[string]bool
+}
+
+// extractImage pulls a bootc container image, extracts kernel, initramfs and EFI binaries into dir,
+// fills OS metadata from its os-release and returns descriptor of the image manifest.
+func extractImage(ctx context.Context, args *PushArgs, dir string) (ocispec.Descriptor, error) {
+	repo := newRepository(args.FromImage, args.Plain)
+	ref := repo.Reference.Reference
+	if ref == "" {
+		ref = "latest"
+	}
+
+	desc, err := repo.Resolve(ctx, ref)
+	if err != nil {
+		return desc, fmt.Errorf("cannot resolve image %s: %w", args.FromImage, err)
+	}
+
+	desc, manifest, err := fetchImageManifest(ctx, repo, desc, args.Architecture)
+	if err != nil {
+		return desc, fmt.Errorf("cannot fetch image manifest: %w", err)
+	}
+
+	configBlob, err := content.FetchAll(ctx, repo, manifest.Config)
+	if err != nil {
+		return desc, fmt.Errorf("cannot fetch image config: %w", err)
+	}
+	var config ocispec.Image
+	if err := json.Unmarshal(configBlob, &config); err != nil {
+		return desc, fmt.Errorf("cannot parse image config: %w", err)
+	}
+
+	arch := netbootArchitecture(config.Architecture)
+	if arch == "" {
+		return desc, fmt.Errorf("unsupported image architecture %s", config.Architecture)
+	}
+	if args.Architecture != "" && args.Architecture != arch {
+		return desc, fmt.Errorf("architecture %s contradicts image architecture %s", args.Architecture, arch)
+	}
+	args.Architecture = arch
+
+	ie := &imageExtractor{
+		dir:     dir,
+		files:   make(map[string]string),
+		targets: make(map[string]string),
+		sources: make(map[string]string),
+	}
+	for _, layer := range manifest.Layers {
+		Debug("extracting layer", layer.Digest.String())
+		if err := ie.extractLayer(ctx, repo, layer); err != nil {
+			return desc, fmt.Errorf("cannot extract layer %s: %w", layer.Digest, err)
+		}
+	}
+
+	osrFile := ie.files["usr/lib/os-release"]
+	if osrFile == "" {
+		osrFile = ie.files["etc/os-release"]
+	}
+	if osrFile == "" {
+		return desc, errors.New("no os-release found in image")
+	}
+	data, err := os.ReadFile(osrFile)
+	if err != nil {
+		return desc, fmt.Errorf("cannot read os-release: %w", err)
+	}
+	osr, err := parseOSRelease(data)
+	if err != nil {
+		return desc, fmt.Errorf("cannot parse os-release: %w", err)
+	}
+	if args.Name != "" && args.Name != osr.Name {
+		return desc, fmt.Errorf("name %s contradicts image name %s", args.Name, osr.Name)
+	}
+	if args.Version != "" && args.Version != osr.Version && !strings.HasPrefix(args.Version, osr.Version+".") {
+		return desc, fmt.Errorf("version %s contradicts image version %s", args.Version, osr.Version)
+	}
+	if args.Name == "" {
+		args.Name = osr.Name
+	}
+	if args.Version == "" {
+		args.Version = osr.Version
+	}
+
+	args.KernelVersion, err = ie.selectKernel()
+	if err != nil {
+		return desc, err
+	}
+
+	for _, role := range []string{"shim", "grub", "mmx64.efi", "mmaa64.efi", "kernel", "initrd"} {
+		if f := ie.files[role]; f != "" {
+			args.File = append(args.File, f)
+		}
+	}
+
+	if args.EntryPoint == "" {
+		if ie.targets["shim"] != "" {
+			args.EntryPoint = ie.targets["shim"]
+			if args.AltEntryPoint == "" {
+				args.AltEntryPoint = ie.targets["grub"]
+			}
+		} else {
+			args.EntryPoint = ie.targets["grub"]
+		}
+	}
+	if args.Kernel == "" {
+		args.Kernel = ie.targets["kernel"]
+	}
+	if args.Initrd == "" {
+		args.Initrd = ie.targets["initrd"]
+	}
+
+	return desc, nil
+}
+
+// fetchImageManifest fetches image manifest, selecting platform by architecture from an index.
+func fetchImageManifest(ctx context.Context, repo *remote.Repository, desc ocispec.Descriptor, arch string) (ocispec.Descriptor, *ocispec.Manifest, error) {
+	blob, err := content.FetchAll(ctx, repo, desc)
+	if err != nil {
+		return desc, nil, err
+	}
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, dockerManifestListMediaType:
+		var index ocispec.Index
+		if err := json.Unmarshal(blob, &index); err != nil {
+			return desc, nil, err
+		}
+
+		if arch == "" && len(index.Manifests) > 1 {
+			return desc, nil, errors.New("image has multiple platforms, use --osarch")
+		}
+		for _, m := range index.Manifests {
+			if arch == "" || (m.Platform != nil && m.Platform.Architecture == ociArchitecture(arch)) {
+				return fetchImageManifest(ctx, repo, m, arch)
+			}
+		}
+		return desc, nil, fmt.Errorf("no image for architecture %s", arch)
+	case ocispec.MediaTypeImageManifest, dockerManifestMediaType:
+		var manifest ocispec.Manifest
+		if err := json.Unmarshal(blob, &manifest); err != nil {
+			return desc, nil, err
+		}
+		return desc, &manifest, nil
+	}
+
+	return desc, nil, fmt.Errorf("unsupported media type %s", desc.MediaType)
+}
+
+func (ie *imageExtractor) extractLayer(ctx context.Context, repo *remote.Repository, layer ocispec.Descriptor) error {
+	ie.current = make(map[string]bool)
+	links, err := ie.readLayer(ctx, repo, layer, nil)
+	if err != nil {
+		return err
+	}
+
+	// hard links point to files earlier in the same layer, read the layer again for them
+	if len(links) > 0 {
+		_, err = ie.readLayer(ctx, repo, layer, links)
+	}
+	return err
+}
+
+// readLayer extracts boot files from a layer and returns hard link targets of boot files. When
+// links is not nil, only the link targets are extracted.
+func (ie *imageExtractor) readLayer(ctx context.Context, repo *remote.Repository, layer ocispec.Descriptor, links map[string]string) (map[string]string, error) {
+	rc, err := repo.Fetch(ctx, layer)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	br := bufio.NewReader(rc)
+	var r io.Reader = br
+	dr, err := decompressReader(br)
+	if err != nil {
+		return nil, err
+	} else if dr != nil {
+		r = dr
+	}
+
+	pending := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return pending, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		name := path.Clean(cpioName(h.Name))
+		if links != nil {
+			if linkName, ok := links[name]; ok {
+				if err := ie.store(linkName, tr); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		base := path.Base(name)
+		if base == ".wh..wh..opq" {
+			ie.remove(path.Dir(name) + "/")
+			continue
+		} else if strings.HasPrefix(base, ".wh.") {
+			ie.remove(path.Join(path.Dir(name), strings.TrimPrefix(base, ".wh.")))
+			continue
+		}
+
+		if imageFileFor(name) == nil {
+			continue
+		}
+
+		switch h.Typeflag {
+		case tar.TypeReg:
+			if err := ie.store(name, tr); err != nil {
+				return nil, err
+			}
+		case tar.TypeLink:
+			pending[path.Clean(cpioName(h.Linkname))] = name
+		}
+	}
+}
+
+func (ie *imageExtractor) store(name string, r io.Reader) error {
+	f := imageFileFor(name)
+	dest := filepath.Join(ie.dir, f.target)
+	if f.version != "" {
+		// kernel versions are kept apart until one is selected
+		dest = filepath.Join(ie.dir, "modules", f.version, f.target)
+		if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+			return err
+		}
+	}
+	Debug("extracting", name, "->", f.target)
+	if err := writeFile(dest, r); err != nil {
+		return err
+	}
+
+	role := f.role
+	if f.version != "" {
+		role = f.role + "/" + f.version
+	}
+	ie.files[role] = dest
+	ie.targets[role] = f.target
+	ie.sources[role] = name
+	ie.current[role] = true
+	return nil
+}
+
+// selectKernel returns version of the only kernel in the image and sets kernel and initrd roles
+// to files of that version, so both always come from the same /usr/lib/modules directory.
+func (ie *imageExtractor) selectKernel() (string, error) {
+	versions := make([]string, 0, 1)
+	for role := range ie.files {
+		if v, ok := strings.CutPrefix(role, "kernel/"); ok {
+			versions = append(versions, v)
+		}
+	}
+	slices.Sort(versions)
+	switch len(versions) {
+	case 0:
+		return "", errors.New("no kernel found in /usr/lib/modules of the image")
+	case 1:
+	default:
+		return "", fmt.Errorf("multiple kernels found in /usr/lib/modules of the image: %s", strings.Join(versions, ", "))
+	}
+
+	v := versions[0]
+	for _, role := range []string{"kernel", "initrd"} {
+		if f := ie.files[role+"/"+v]; f != "" {
+			ie.files[role] = f
+			ie.targets[role] = ie.targets[role+"/"+v]
+		}
+	}
+	return v, nil
+}
+
+// remove removes boot files of lower layers matching whiteout path or under it when the path is
+// a directory, path with trailing slash (opaque whiteout) removes all files in the directory.
+func (ie *imageExtractor) remove(name string) {
+	dir := strings.TrimSuffix(name, "/") + "/"
+	for role, source := range ie.sources {
+		if ie.current[role] {
+			continue
+		}
+		if source == name || strings.HasPrefix(source, dir) {
+			Debug("removing whiteout", source)
+			os.Remove(ie.files[role])
+			delete(ie.files, role)
+			delete(ie.targets, role)
+			delete(ie.sources, role)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package nboci
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	ggcrremote "github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// testTarEntry is a regular file, or a hard link when link is set.
+type testTarEntry struct {
+	name string
+	data string
+	link string
+}
+
+func testLayer(t *testing.T, entries ...testTarEntry) v1.Layer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.data)), Typeflag: tar.TypeReg}
+		if e.link != "" {
+			h.Typeflag, h.Linkname, h.Size = tar.TypeLink, e.link, 0
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return static.NewLayer(testGzip(t, buf.Bytes()), types.OCILayer)
+}
+
+// pushTestBootcImage pushes an amd64 image with the layers into an in-memory registry and
+// returns its reference.
+func pushTestBootcImage(t *testing.T, layers ...v1.Layer) string {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+	reference := strings.TrimPrefix(server.URL, "http://") + "/bootc:latest"
+
+	img, err := mutate.AppendLayers(empty.Image, layers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err = mutate.ConfigFile(img, &v1.ConfigFile{Architecture: "amd64", OS: "linux"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := name.NewTag(reference, name.Insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// image write does not upload static layers
+	for _, l := range layers {
+		if err := ggcrremote.WriteLayer(tag.Context(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ggcrremote.Write(tag, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return reference
+}
+
+func TestExtractImage(t *testing.T) {
+	base := testLayer(t,
+		testTarEntry{name: "usr/lib/os-release", data: "ID=fedora\nVERSION_ID=41\n"},
+		testTarEntry{name: "usr/lib/modules/6.8.0/vmlinuz", data: "kernel 6.8"},
+		testTarEntry{name: "usr/lib/modules/6.8.0/initramfs.img", data: "initrd 6.8"},
+		testTarEntry{name: "usr/lib/bootupd/updates/EFI/fedora/shimx64.efi", data: "shim"},
+		testTarEntry{name: "usr/lib/bootupd/updates/EFI/fedora/grubx64.efi", data: "grub"},
+	)
+
+	tests := []struct {
+		name    string
+		layers  []v1.Layer
+		version string
+		files   map[string]string
+		removed []string
+		err     string
+	}{
+		{
+			name:    "single layer",
+			layers:  []v1.Layer{base},
+			version: "6.8.0",
+			files:   map[string]string{"vmlinuz": "kernel 6.8", "initrd.img": "initrd 6.8", "shim.efi": "shim", "grubx64.efi": "grub"},
+		},
+		{
+			name: "kernel replaced with plain directory whiteout",
+			layers: []v1.Layer{base, testLayer(t,
+				testTarEntry{name: "usr/lib/modules/.wh.6.8.0"},
+				testTarEntry{name: "usr/lib/modules/6.9.0/vmlinuz", data: "kernel 6.9"},
+				testTarEntry{name: "usr/lib/modules/6.9.0/initramfs.img", data: "initrd 6.9"},
+			)},
+			version: "6.9.0",
+			files:   map[string]string{"vmlinuz": "kernel 6.9", "initrd.img": "initrd 6.9", "shim.efi": "shim"},
+		},
+		{
+			name: "kernel replaced with opaque whiteout",
+			layers: []v1.Layer{base, testLayer(t,
+				// files of the same layer are kept regardless of the order
+				testTarEntry{name: "usr/lib/modules/6.9.0/vmlinuz", data: "kernel 6.9"},
+				testTarEntry{name: "usr/lib/modules/.wh..wh..opq"},
+				testTarEntry{name: "usr/lib/modules/6.9.0/initramfs.img", data: "initrd 6.9"},
+			)},
+			version: "6.9.0",
+			files:   map[string]string{"vmlinuz": "kernel 6.9", "initrd.img": "initrd 6.9", "grubx64.efi": "grub"},
+		},
+		{
+			name: "file whiteout",
+			layers: []v1.Layer{base, testLayer(t,
+				testTarEntry{name: "usr/lib/bootupd/updates/EFI/fedora/.wh.shimx64.efi"},
+			)},
+			version: "6.8.0",
+			files:   map[string]string{"vmlinuz": "kernel 6.8", "grubx64.efi": "grub"},
+			removed: []string{"shim.efi"},
+		},
+		{
+			name: "hard link",
+			layers: []v1.Layer{testLayer(t,
+				testTarEntry{name: "usr/lib/os-release", data: "ID=fedora\nVERSION_ID=41\n"},
+				testTarEntry{name: "usr/lib/kernel/vmlinuz-6.8.0", data: "kernel 6.8"},
+				testTarEntry{name: "usr/lib/modules/6.8.0/vmlinuz", link: "usr/lib/kernel/vmlinuz-6.8.0"},
+			)},
+			version: "6.8.0",
+			files:   map[string]string{"vmlinuz": "kernel 6.8"},
+		},
+		{
+			name: "two kernels",
+			layers: []v1.Layer{base, testLayer(t,
+				testTarEntry{name: "usr/lib/modules/6.9.0/vmlinuz", data: "kernel 6.9"},
+			)},
+			err: "multiple kernels found in /usr/lib/modules of the image: 6.8.0, 6.9.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reference := pushTestBootcImage(t, tt.layers...)
+			dir := t.TempDir()
+			args := &PushArgs{FromImage: reference, Plain: true}
+
+			_, err := extractImage(context.Background(), args, dir)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if args.KernelVersion != tt.version || args.Name != "fedora" || args.Version != "41" || args.Architecture != "x86_64" {
+				t.Fatalf("unexpected metadata %s %s %s kernel %s", args.Name, args.Version, args.Architecture, args.KernelVersion)
+			}
+			found := make(map[string]string)
+			for _, f := range args.File {
+				data, err := os.ReadFile(f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				found[filepath.Base(f)] = string(data)
+			}
+			for name, data := range tt.files {
+				if found[name] != data {
+					t.Fatalf("file %s has content %q, expected %q (files %v)", name, found[name], data, found)
+				}
+			}
+			for _, name := range tt.removed {
+				if _, ok := found[name]; ok {
+					t.Fatalf("removed file %s was extracted", name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package nboci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+// updateIndex creates or updates an image index under the tag, replacing the entry with the same
+// architecture by the manifest.
+func updateIndex(ctx context.Context, repo *remote.Repository, tag string, manifest ocispec.Descriptor, args PushArgs) error {
+	index := ocispec.Index{
+		Versioned:    specs.Versioned{SchemaVersion: 2},
+		MediaType:    ocispec.MediaTypeImageIndex,
+		ArtifactType: NetbootArtifactType,
+		Annotations: map[string]string{
+			AnnotationSchemaVersion: NetbootSchemaVersion,
+			AnnotationOSName:        args.Name,
+			AnnotationOSVersion:     args.Version,
+		},
+	}
+
+	existing, err := repo.Resolve(ctx, tag)
+	if err == nil {
+		if existing.MediaType != ocispec.MediaTypeImageIndex {
+			return fmt.Errorf("tag %s exists and it is not an index", tag)
+		}
+
+		blob, err := content.FetchAll(ctx, repo, existing)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(blob, &index); err != nil {
+			return err
+		}
+		if index.Annotations[AnnotationOSName] != args.Name || index.Annotations[AnnotationOSVersion] != args.Version {
+			return fmt.Errorf("index %s is for %s %s", tag, index.Annotations[AnnotationOSName], index.Annotations[AnnotationOSVersion])
+		}
+	} else if !errors.Is(err, errdef.ErrNotFound) {
+		return err
+	}
+
+	index.Manifests = slices.DeleteFunc(index.Manifests, func(d ocispec.Descriptor) bool {
+		return descriptorArchitecture(d) == args.Architecture
+	})
+
+	entry := manifest
+	entry.Platform = &ocispec.Platform{
+		OS:           "linux",
+		Architecture: ociArchitecture(args.Architecture),
+	}
+	entry.ArtifactType = NetbootArtifactType
+	entry.Annotations = map[string]string{
+		AnnotationOSArch: args.Architecture,
+	}
+	index.Manifests = append(index.Manifests, entry)
+
+	blob, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	desc := content.NewDescriptorFromBytes(ocispec.MediaTypeImageIndex, blob)
+	return publish(ctx, repo, desc, blob, []string{tag}, args)
+}
+
+// descriptorArchitecture returns architecture of an index entry.
+func descriptorArchitecture(d ocispec.Descriptor) string {
+	if arch := d.Annotations[AnnotationOSArch]; arch != "" {
+		return arch
+	}
+	if d.Platform != nil {
+		return netbootArchitecture(d.Platform.Architecture)
+	}
+	return ""
+}
+
+// pullIndex pulls manifests of an index for selected architectures.
+func pullIndex(ctx context.Context, repo *remote.Repository, desc ocispec.Descriptor, args PullArgs) error {
+	blob, err := content.FetchAll(ctx, repo, desc)
+	if err != nil {
+		return err
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(blob, &index); err != nil {
+		return err
+	}
+
+	for _, m := range index.Manifests {
+		if m.MediaType != ocispec.MediaTypeImageManifest || (m.ArtifactType != "" && !IsNetbootArtifact(m.ArtifactType, m.Annotations)) {
+			continue
+		}
+		if len(args.Arch) > 0 && !slices.Contains(args.Arch, descriptorArchitecture(m)) {
+			Debug("skipping architecture", descriptorArchitecture(m))
+			continue
+		}
+
+		if err := pullDescriptor(ctx, repo, m, args); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package nboci
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+)
+
+type InspectArgs struct {
+	Source string `arg:"positional,required" help:"repository:tag" placeholder:"REPOSITORY:{TAG|DIGEST}"`
+	Plain  bool   `arg:"-N,--plain" help:"plain HTTP (insecure)"`
+}
+
+func Inspect(ctx context.Context, args InspectArgs) {
+	repo := newRepository(args.Source, args.Plain)
+	if repo.Reference.Reference == "" {
+		Fatal("missing tag or digest in", args.Source)
+	}
+
+	desc, err := repo.Resolve(ctx, repo.Reference.Reference)
+	if err != nil {
+		FatalErr(err, "cannot resolve", args.Source)
+	}
+
+	if desc.MediaType != ocispec.MediaTypeImageManifest {
+		Fatal("unsupported media type", desc.MediaType)
+	}
+
+	blob, err := content.FetchAll(ctx, repo, desc)
+	if err != nil {
+		FatalErr(err, "cannot fetch manifest")
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(blob, &manifest); err != nil {
+		FatalErr(err, "cannot parse manifest")
+	}
+
+	a := manifest.Annotations
+	config, err := loadNetbootConfig(ctx, repo, &manifest)
+	if err != nil {
+		FatalErr(err, "cannot load netboot config")
+	}
+
+	Printf("Digest:            %s\n", desc.Digest)
+	Printf("Artifact type:     %s\n", manifest.ArtifactType)
+	if IsLegacyArtifact(manifest.ArtifactType, a) {
+		Printf("Schema version:    legacy\n")
+	} else {
+		Printf("Schema version:    %s\n", config.SchemaVersion)
+	}
+	Printf("Config:            %s\n", manifest.Config.MediaType)
+	Printf("OS:                %s %s %s\n", config.OS.Name, config.OS.Version, config.OS.Architecture)
+	Printf("Entrypoint:        %s\n", config.EntryPoints.Default)
+	Printf("Alt entrypoint:    %s\n", config.EntryPoints.Alternate)
+	Printf("Legacy entrypoint: %s\n", config.EntryPoints.Legacy)
+	if config.Kernel != nil {
+		Printf("Kernel:            %s\n", config.Kernel.File)
+		Printf("Kernel version:    %s\n", config.Kernel.Version)
+		Printf("Initrd:            %s\n", config.Kernel.Initrd)
+		Printf("Kernel arguments:  %s\n", config.Kernel.Args)
+	}
+	if a[AnnotationRPMs] != "" {
+		Printf("Packages:          %s\n", a[AnnotationRPMs])
+	}
+	Print("Files:")
+	for _, f := range config.Files {
+		Printf("  %-20s %10d %s %s\n", f.Name, f.Size, f.Digest, strings.Join(f.Roles, ","))
+	}
+	for _, l := range manifest.Layers {
+		if signer := l.Annotations[AnnotationSrcSigner]; signer != "" {
+			Printf("Signer:            %s %s\n", l.Annotations[AnnotationTitle], signer)
+		}
+	}
+	for _, l := range manifest.Layers {
+		if sbat := l.Annotations[AnnotationSrcSBAT]; sbat != "" {
+			Printf("SBAT:              %s\n", l.Annotations[AnnotationTitle])
+			for _, line := range strings.Split(sbat, "\n") {
+				Printf("  %s\n", strings.TrimSpace(line))
+			}
+		}
+	}
+
+	// attestations are optional, inspect still shows the artifact when they cannot be read
+	atts, err := listAttestations(ctx, repo, desc, args.Plain)
+	if err != nil {
+		ErrorErr(err, "cannot list attestations")
+	}
+	if len(atts) > 0 {
+		Print("Attestations:")
+	}
+	for _, att := range atts {
+		digest, err := att.Digest()
+		if err != nil {
+			ErrorErr(err, "cannot read attestation")
+			continue
+		}
+		statement, err := attestationStatement(att)
+		if err != nil {
+			Printf("  %s (invalid: %s)\n", digest, err)
+			continue
+		}
+		Printf("  %s %s (not verified)\n", statement.PredicateType, digest)
+	}
+}
@@ -0,0 +1,236 @@
+package nboci
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// isoFile is a boot file with a role in an installation ISO.
+type isoFile struct {
+	role   string
+	path   string
+	target string
+}
+
+// isoWellKnownFiles are paths of boot files in installation ISOs per architecture.
+var isoWellKnownFiles = map[string][]isoFile{
+	"x86_64": {
+		{role: "shim", path: "EFI/BOOT/BOOTX64.EFI", target: "shim.efi"},
+		{role: "grub", path: "EFI/BOOT/grubx64.efi", target: "grubx64.efi"},
+		{role: "kernel", path: "images/pxeboot/vmlinuz", target: "vmlinuz"},
+		{role: "initrd", path: "images/pxeboot/initrd.img", target: "initrd.img"},
+	},
+	"aarch64": {
+		{role: "shim", path: "EFI/BOOT/BOOTAA64.EFI", target: "shim.efi"},
+		{role: "grub", path: "EFI/BOOT/grubaa64.efi", target: "grubaa64.efi"},
+		{role: "kernel", path: "images/pxeboot/vmlinuz", target: "vmlinuz"},
+		{role: "initrd", path: "images/pxeboot/initrd.img", target: "initrd.img"},
+	},
+	"ppc64le": {
+		{role: "grub", path: "boot/grub/powerpc-ieee1275/core.elf", target: "core.elf"},
+		{role: "kernel", path: "ppc/ppc64/vmlinuz", target: "vmlinuz"},
+		{role: "initrd", path: "ppc/ppc64/initrd.img", target: "initrd.img"},
+	},
+}
+
+// extractISO extracts boot files from installation ISO into dir, appends them to the list of
+// pushed files and fills entrypoints, kernel, initrd and OS metadata when not provided.
+func extractISO(args *PushArgs, dir string) error {
+	f, err := os.Open(args.FromISO)
+	if err != nil {
+		return fmt.Errorf("cannot open ISO: %w", err)
+	}
+	defer f.Close()
+
+	iso, err := OpenISO(f)
+	if err != nil {
+		return fmt.Errorf("cannot read ISO %s: %w", args.FromISO, err)
+	}
+
+	ti, err := readTreeinfo(iso)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		ErrorErr(err, "cannot read .treeinfo")
+	}
+	if ti != nil {
+		Debug("found .treeinfo", ti.name, ti.version, ti.arch)
+		if args.Name == "" {
+			args.Name = ti.name
+		}
+		if args.Version == "" {
+			args.Version = ti.version
+		}
+		if args.Architecture == "" {
+			args.Architecture = ti.arch
+		}
+	}
+
+	if args.Architecture == "" {
+		for arch, files := range isoWellKnownFiles {
+			if _, err := iso.Lookup(files[0].path); err == nil {
+				Debug("detected architecture", arch)
+				args.Architecture = arch
+				break
+			}
+		}
+	}
+
+	files, err := isoFiles(args.Architecture, args.ISOFile)
+	if err != nil {
+		return fmt.Errorf("invalid ISO file selection: %w", err)
+	}
+
+	roles := make(map[string]string)
+	for _, file := range files {
+		r, err := iso.Open(file.path)
+		if errors.Is(err, fs.ErrNotExist) {
+			Debug("file not found in ISO", file.path)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("cannot read %s from ISO: %w", file.path, err)
+		}
+
+		dest := filepath.Join(dir, file.target)
+		Debug("extracting", file.path, "->", file.target)
+		if err := writeFile(dest, r); err != nil {
+			return fmt.Errorf("cannot extract %s: %w", file.path, err)
+		}
+
+		args.File = append(args.File, dest)
+		roles[file.role] = file.target
+	}
+
+	if roles["kernel"] == "" {
+		return errors.New("no kernel found in ISO, use --iso-file kernel=PATH")
+	}
+
+	if args.EntryPoint == "" {
+		if roles["shim"] != "" {
+			args.EntryPoint = roles["shim"]
+			if args.AltEntryPoint == "" {
+				args.AltEntryPoint = roles["grub"]
+			}
+		} else {
+			args.EntryPoint = roles["grub"]
+		}
+	}
+	if args.Kernel == "" {
+		args.Kernel = roles["kernel"]
+	}
+	if args.Initrd == "" {
+		args.Initrd = roles["initrd"]
+	}
+
+	return nil
+}
+
+// isoFiles returns well-known files for the architecture with explicit ROLE=PATH overrides.
+func isoFiles(arch string, overrides []string) ([]isoFile, error) {
+	files := make([]isoFile, 0)
+	files = append(files, isoWellKnownFiles[arch]...)
+
+	for _, o := range overrides {
+		role, p, ok := strings.Cut(o, "=")
+		if !ok || p == "" {
+			return nil, errors.New("ISO file must be in ROLE=PATH format: " + o)
+		}
+
+		target := path.Base(p)
+		switch role {
+		case "shim":
+			target = "shim.efi"
+		case "kernel":
+			target = "vmlinuz"
+		case "initrd":
+			target = "initrd.img"
+		case "grub":
+			target = strings.ToLower(target)
+		default:
+			return nil, errors.New("unknown ISO file role: " + role)
+		}
+
+		replaced := false
+		for i := range files {
+			if files[i].role == role {
+				files[i] = isoFile{role: role, path: p, target: target}
+				replaced = true
+			}
+		}
+		if !replaced {
+			files = append(files, isoFile{role: role, path: p, target: target})
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("unknown architecture, use --osarch")
+	}
+
+	return files, nil
+}
+
+func writeFile(filename string, r io.Reader) error {
+	w, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	_, err = io.Copy(w, r)
+	return err
+}
+
+type treeinfo struct {
+	name    string
+	version string
+	arch    string
+}
+
+// readTreeinfo reads OS metadata from .treeinfo file of installation media.
+func readTreeinfo(iso *ISOImage) (*treeinfo, error) {
+	r, err := iso.Open(".treeinfo")
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	section := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.Trim(line, "[]")
+			continue
+		}
+
+		k, v, ok := strings.Cut(line, "=")
+		if ok {
+			values[section+"."+strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	ti := &treeinfo{
+		name:    strings.ToLower(values["release.short"]),
+		version: strings.ToLower(values["release.version"]),
+		arch:    values["tree.arch"],
+	}
+	if ti.name == "" {
+		ti.name = strings.ToLower(values["general.family"])
+	}
+	if ti.version == "" {
+		ti.version = strings.ToLower(values["general.version"])
+	}
+	if ti.arch == "" {
+		ti.arch = values["general.arch"]
+	}
+
+	return ti, nil
+}
@@ -0,0 +1,300 @@
+package nboci
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"unicode/utf16"
+)
+
+const isoSectorSize = 2048
+
+// isoMaxDirSize limits the size of directories read from untrusted images.
+const isoMaxDirSize = 16 * 1024 * 1024
+
+// isoMaxContinuations limits the number of Rock Ridge continuation areas of a single record.
+const isoMaxContinuations = 8
+
+// ISOEntry is a file or directory in ISO9660 image.
+type ISOEntry struct {
+	Name   string
+	Extent int64
+	Size   int64
+	Dir    bool
+}
+
+// ISOImage is a read-only ISO9660 image with Joliet and Rock Ridge extensions. Rock Ridge names
+// are preferred, then Joliet names and finally plain ISO9660 names.
+type ISOImage struct {
+	r         io.ReaderAt
+	root      ISOEntry
+	joliet    bool
+	rockRidge bool
+	susp      int
+}
+
+func OpenISO(r io.ReaderAt) (*ISOImage, error) {
+	var primary, joliet *ISOEntry
+
+descriptors:
+	for sector := int64(16); ; sector++ {
+		vd := make([]byte, isoSectorSize)
+		if _, err := r.ReadAt(vd, sector*isoSectorSize); err != nil {
+			return nil, fmt.Errorf("cannot read volume descriptor: %w", err)
+		}
+		if string(vd[1:6]) != "CD001" {
+			return nil, errors.New("not an ISO9660 image")
+		}
+
+		switch vd[0] {
+		case 1:
+			e, _, err := parseISORecord(vd[156:190], false)
+			if err != nil {
+				return nil, err
+			}
+			primary = &e
+		case 2:
+			esc := string(vd[88:91])
+			if esc == "%/@" || esc == "%/C" || esc == "%/E" {
+				e, _, err := parseISORecord(vd[156:190], true)
+				if err != nil {
+					return nil, err
+				}
+				joliet = &e
+			}
+		case 255:
+			break descriptors
+		}
+	}
+
+	if primary == nil {
+		return nil, errors.New("no primary volume descriptor")
+	}
+
+	iso := &ISOImage{r: r, root: *primary}
+	if err := iso.detectRockRidge(); err != nil {
+		return nil, err
+	}
+
+	if !iso.rockRidge && joliet != nil {
+		iso.root = *joliet
+		iso.joliet = true
+	}
+
+	return iso, nil
+}
+
+// detectRockRidge looks for SUSP "SP" entry in the root directory "." record.
+func (iso *ISOImage) detectRockRidge() error {
+	buf := make([]byte, isoSectorSize)
+	if _, err := iso.r.ReadAt(buf, iso.root.Extent*isoSectorSize); err != nil {
+		return err
+	}
+
+	length := int(buf[0])
+	if length < 34 {
+		return nil
+	}
+	su := systemUse(buf[:length])
+	if len(su) >= 7 && string(su[0:2]) == "SP" && su[4] == 0xbe && su[5] == 0xef {
+		iso.rockRidge = true
+		iso.susp = int(su[6])
+	}
+
+	return nil
+}
+
+func systemUse(rec []byte) []byte {
+	nameLen := int(rec[32])
+	start := 33 + nameLen
+	if nameLen%2 == 0 {
+		start++
+	}
+	if start > len(rec) {
+		return nil
+	}
+	return rec[start:]
+}
+
+func parseISORecord(rec []byte, joliet bool) (ISOEntry, []byte, error) {
+	nameLen := int(rec[32])
+	if 33+nameLen > len(rec) {
+		return ISOEntry{}, nil, errors.New("invalid directory record name length")
+	}
+	raw := rec[33 : 33+nameLen]
+
+	var name string
+	switch {
+	case nameLen == 1 && raw[0] == 0:
+		name = "."
+	case nameLen == 1 && raw[0] == 1:
+		name = ".."
+	case joliet:
+		u := make([]uint16, 0, nameLen/2)
+		for i := 0; i+1 < nameLen; i += 2 {
+			u = append(u, binary.BigEndian.Uint16(raw[i:]))
+		}
+		name = string(utf16.Decode(u))
+	default:
+		name = string(raw)
+	}
+
+	if i := strings.LastIndex(name, ";"); i > 0 {
+		name = name[:i]
+	}
+	if rec[25]&0x02 == 0 {
+		name = strings.TrimSuffix(name, ".")
+	}
+
+	return ISOEntry{
+		Name:   name,
+		Extent: int64(binary.LittleEndian.Uint32(rec[2:])),
+		Size:   int64(binary.LittleEndian.Uint32(rec[10:])),
+		Dir:    rec[25]&0x02 != 0,
+	}, systemUse(rec), nil
+}
+
+// rockRidgeName returns alternate name from Rock Ridge NM entries or empty string. Entries which
+// do not fit into the directory record are read from continuation areas of CE entries.
+func (iso *ISOImage) rockRidgeName(su []byte) (string, error) {
+	name := bytes.Buffer{}
+	for areas := 0; su != nil; areas++ {
+		if areas > isoMaxContinuations {
+			return "", errors.New("too many Rock Ridge continuation areas")
+		}
+
+		var ce []byte
+		for len(su) >= 4 {
+			length := int(su[2])
+			if length < 4 || length > len(su) {
+				break
+			}
+			entry := su[:length]
+			su = su[length:]
+
+			switch string(entry[0:2]) {
+			case "NM":
+				// current and parent directory flags
+				if length >= 5 && entry[4]&0x06 == 0 {
+					name.Write(entry[5:])
+				}
+			case "CE":
+				if length >= 28 {
+					ce = entry
+				}
+			case "ST":
+				su = nil
+			}
+		}
+
+		su = nil
+		if ce != nil {
+			extent := int64(binary.LittleEndian.Uint32(ce[4:]))
+			offset := int64(binary.LittleEndian.Uint32(ce[12:]))
+			size := int64(binary.LittleEndian.Uint32(ce[20:]))
+			if offset+size > isoSectorSize {
+				return "", errors.New("invalid Rock Ridge continuation area")
+			}
+			su = make([]byte, size)
+			if _, err := iso.r.ReadAt(su, extent*isoSectorSize+offset); err != nil {
+				return "", fmt.Errorf("cannot read Rock Ridge continuation area: %w", err)
+			}
+		}
+	}
+
+	return name.String(), nil
+}
+
+func (iso *ISOImage) readDir(dir ISOEntry) ([]ISOEntry, error) {
+	if dir.Size > isoMaxDirSize {
+		return nil, fmt.Errorf("directory size %d exceeds %d bytes", dir.Size, isoMaxDirSize)
+	}
+	buf := make([]byte, dir.Size)
+	if _, err := iso.r.ReadAt(buf, dir.Extent*isoSectorSize); err != nil {
+		return nil, err
+	}
+
+	result := make([]ISOEntry, 0)
+	for pos := 0; pos < len(buf); {
+		length := int(buf[pos])
+		if length == 0 {
+			// records do not cross sector boundary
+			pos = (pos/isoSectorSize + 1) * isoSectorSize
+			continue
+		}
+		if pos+length > len(buf) || length < 34 {
+			return nil, errors.New("invalid directory record")
+		}
+
+		e, su, err := parseISORecord(buf[pos:pos+length], iso.joliet)
+		if err != nil {
+			return nil, err
+		}
+		pos += length
+		if e.Name == "." || e.Name == ".." {
+			continue
+		}
+
+		if iso.rockRidge && len(su) > iso.susp {
+			rr, err := iso.rockRidgeName(su[iso.susp:])
+			if err != nil {
+				return nil, err
+			}
+			if rr != "" {
+				e.Name = rr
+			}
+		}
+		result = append(result, e)
+	}
+
+	return result, nil
+}
+
+// Lookup finds an entry by slash separated path, names are matched case-insensitively.
+func (iso *ISOImage) Lookup(p string) (*ISOEntry, error) {
+	current := iso.root
+	for _, part := range strings.Split(strings.Trim(p, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		if !current.Dir {
+			return nil, fs.ErrNotExist
+		}
+
+		entries, err := iso.readDir(current)
+		if err != nil {
+			return nil, err
+		}
+
+		found := false
+		for _, e := range entries {
+			if strings.EqualFold(e.Name, part) {
+				current = e
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fs.ErrNotExist
+		}
+	}
+
+	return &current, nil
+}
+
+// Open returns reader of a regular file contents.
+func (iso *ISOImage) Open(p string) (*io.SectionReader, error) {
+	e, err := iso.Lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	if e.Dir {
+		return nil, fmt.Errorf("%s is a directory", p)
+	}
+
+	return io.NewSectionReader(iso.r, e.Extent*isoSectorSize, e.Size), nil
+}
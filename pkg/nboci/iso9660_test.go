@@ -0,0 +1,242 @@
+package nboci
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+// testISOEntry is a file or directory of a generated ISO9660 image.
+type testISOEntry struct {
+	iso      string // ISO9660 name
+	rr       string // Rock Ridge name
+	ce       bool   // Rock Ridge name is stored in a continuation area
+	data     string
+	children []testISOEntry
+}
+
+// testISO builds ISO9660 images sector by sector.
+type testISO struct {
+	buf       []byte
+	rockRidge bool
+}
+
+func (b *testISO) alloc(data []byte) uint32 {
+	sector := len(b.buf) / isoSectorSize
+	sectors := max(1, (len(data)+isoSectorSize-1)/isoSectorSize)
+	b.buf = append(b.buf, make([]byte, sectors*isoSectorSize)...)
+	copy(b.buf[sector*isoSectorSize:], data)
+	return uint32(sector)
+}
+
+func putBoth32(buf []byte, v uint32) {
+	binary.LittleEndian.PutUint32(buf, v)
+	binary.BigEndian.PutUint32(buf[4:], v)
+}
+
+func testISORecord(name []byte, extent uint32, size int, dir bool, su []byte) []byte {
+	n := 33 + len(name)
+	if len(name)%2 == 0 {
+		n++
+	}
+	rec := append(make([]byte, n), su...)
+	if len(rec)%2 == 1 {
+		rec = append(rec, 0)
+	}
+	rec[0] = byte(len(rec))
+	putBoth32(rec[2:], extent)
+	putBoth32(rec[10:], uint32(size))
+	if dir {
+		rec[25] = 0x02
+	}
+	rec[32] = byte(len(name))
+	copy(rec[33:], name)
+	return rec
+}
+
+func testNM(name string) []byte {
+	return append([]byte{'N', 'M', byte(5 + len(name)), 1, 0}, name...)
+}
+
+// write stores children of a directory entry and the directory itself, returning its record.
+func (b *testISO) write(e testISOEntry, root bool) []byte {
+	if e.children == nil {
+		extent := b.alloc([]byte(e.data))
+		return b.record(e, extent, len(e.data), false)
+	}
+
+	records := make([][]byte, 0, len(e.children))
+	for _, c := range e.children {
+		records = append(records, b.write(c, false))
+	}
+
+	dot := []byte(nil)
+	if root && b.rockRidge {
+		dot = []byte{'S', 'P', 7, 1, 0xbe, 0xef, 0}
+	}
+	extent := uint32(len(b.buf) / isoSectorSize)
+	dir := testISORecord([]byte{0}, extent, isoSectorSize, true, dot)
+	dir = append(dir, testISORecord([]byte{1}, extent, isoSectorSize, true, nil)...)
+	for _, r := range records {
+		dir = append(dir, r...)
+	}
+	b.alloc(dir)
+
+	return b.record(e, extent, isoSectorSize, true)
+}
+
+func (b *testISO) record(e testISOEntry, extent uint32, size int, dir bool) []byte {
+	var su []byte
+	if b.rockRidge && e.rr != "" {
+		su = testNM(e.rr)
+		if e.ce {
+			area := b.alloc(su)
+			su = []byte{'C', 'E', 28, 1}
+			su = append(su, make([]byte, 24)...)
+			putBoth32(su[4:], area)
+			putBoth32(su[20:], uint32(5+len(e.rr)))
+		}
+	}
+	return testISORecord([]byte(e.iso), extent, size, dir, su)
+}
+
+// buildTestISO returns an image with a primary volume descriptor of the root directory.
+func buildTestISO(root testISOEntry, rockRidge bool) []byte {
+	b := &testISO{buf: make([]byte, 18*isoSectorSize), rockRidge: rockRidge}
+	root.iso = "\x00"
+	rec := b.write(root, true)
+
+	pvd := b.buf[16*isoSectorSize:]
+	pvd[0] = 1
+	copy(pvd[1:], "CD001")
+	copy(pvd[156:190], rec)
+	term := b.buf[17*isoSectorSize:]
+	term[0] = 255
+	copy(term[1:], "CD001")
+
+	return b.buf
+}
+
+func testISOTree() testISOEntry {
+	long := strings.Repeat("x", 200) + ".img"
+	return testISOEntry{children: []testISOEntry{
+		{iso: "IMAGES", rr: "images", children: []testISOEntry{
+			{iso: "PXEBOOT", rr: "pxeboot", children: []testISOEntry{
+				{iso: "VMLINUZ.;1", rr: "vmlinuz", data: "kernel"},
+				{iso: "INITRD.IMG;1", rr: "initrd.img", data: strings.Repeat("i", 3000)},
+				{iso: "XXXXXXXX.IMG;1", rr: long, ce: true, data: "long"},
+			}},
+		}},
+		{iso: "EFI", rr: "EFI", children: []testISOEntry{
+			{iso: "BOOT", rr: "BOOT", children: []testISOEntry{
+				{iso: "BOOTX64.EFI;1", rr: "BOOTX64.EFI", data: "shim"},
+			}},
+		}},
+	}}
+}
+
+func TestISOImage(t *testing.T) {
+	long := "images/pxeboot/" + strings.Repeat("x", 200) + ".img"
+	tests := []struct {
+		name      string
+		rockRidge bool
+		files     map[string]string
+	}{
+		{
+			name:      "rock ridge",
+			rockRidge: true,
+			files: map[string]string{
+				"images/pxeboot/vmlinuz":     "kernel",
+				"/images/pxeboot/initrd.img": strings.Repeat("i", 3000),
+				long:                         "long",
+				"efi/boot/bootx64.efi":       "shim",
+			},
+		},
+		{
+			name: "plain",
+			files: map[string]string{
+				"IMAGES/PXEBOOT/VMLINUZ":      "kernel",
+				"images/pxeboot/initrd.img":   strings.Repeat("i", 3000),
+				"images/pxeboot/xxxxxxxx.img": "long",
+				"EFI/BOOT/BOOTX64.EFI":        "shim",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iso, err := OpenISO(bytes.NewReader(buildTestISO(testISOTree(), tt.rockRidge)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if iso.rockRidge != tt.rockRidge {
+				t.Fatalf("rock ridge detected %v", iso.rockRidge)
+			}
+
+			for name, data := range tt.files {
+				r, err := iso.Open(name)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				content, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(content) != data {
+					t.Fatalf("%s: unexpected content %q", name, content)
+				}
+			}
+
+			if _, err := iso.Open("images/pxeboot/missing"); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("expected not exist error, got %v", err)
+			}
+			if _, err := iso.Open("images/pxeboot/vmlinuz/file"); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("expected not exist error for file path, got %v", err)
+			}
+			if _, err := iso.Open("images"); err == nil {
+				t.Fatal("directory opened as a file")
+			}
+		})
+	}
+}
+
+func TestISOImageErrors(t *testing.T) {
+	image := buildTestISO(testISOTree(), true)
+	root := 16*isoSectorSize + 156
+	tests := []struct {
+		name  string
+		patch func(buf []byte)
+		err   string
+	}{
+		{"not iso", func(buf []byte) { copy(buf[16*isoSectorSize+1:], "XXXXX") }, "not an ISO9660 image"},
+		{"directory too large", func(buf []byte) { putBoth32(buf[root+10:], 0xffffffff) }, "exceeds"},
+		{"directory past end", func(buf []byte) { putBoth32(buf[root+2:], 0x100000) }, "EOF"},
+		{"continuation past sector", func(buf []byte) {
+			ce := bytes.Index(buf, []byte{'C', 'E', 28, 1})
+			putBoth32(buf[ce+12:], isoSectorSize)
+		}, "invalid Rock Ridge continuation area"},
+		{"continuation loop", func(buf []byte) {
+			// continuation area referencing itself
+			ce := bytes.Index(buf, []byte{'C', 'E', 28, 1})
+			area := binary.LittleEndian.Uint32(buf[ce+4:])
+			copy(buf[area*isoSectorSize:], buf[ce:ce+28])
+			putBoth32(buf[area*isoSectorSize+20:], 28)
+		}, "too many Rock Ridge continuation areas"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := bytes.Clone(image)
+			tt.patch(buf)
+			iso, err := OpenISO(bytes.NewReader(buf))
+			if err == nil {
+				_, err = iso.Lookup("images/pxeboot/vmlinuz")
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -2,39 +2,384 @@ package nboci
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 
+	notationregistry "github.com/notaryproject/notation-go/registry"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
 	"oras.land/oras-go/v2/registry/remote"
-	"oras.land/oras-go/v2/registry/remote/auth"
-	"oras.land/oras-go/v2/registry/remote/retry"
 )
 
 type ListArgs struct {
 	Source string `arg:"positional,required" help:"repository" placeholder:"REPOSITORY"`
+	Plain  bool   `arg:"-N,--plain" help:"plain HTTP (insecure)"`
+	Output string `arg:"-o,--output" default:"text" help:"output format (text, json)" placeholder:"FORMAT"`
+	Sort   string `arg:"-s,--sort" default:"tag" help:"sort netboot tags by tag, os or version" placeholder:"KEY"`
+	All    bool   `arg:"--all" help:"list also tags which are not netboot artifacts"`
+}
+
+// ListEntry is a netboot tag in list output.
+type ListEntry struct {
+	Tag              string   `json:"tag"`
+	Digest           string   `json:"digest"`
+	MediaType        string   `json:"mediaType"`
+	Schema           string   `json:"schema"`
+	Name             string   `json:"name"`
+	Version          string   `json:"version"`
+	Architectures    []string `json:"architectures"`
+	EntryPoint       string   `json:"entrypoint,omitempty"`
+	AltEntryPoint    string   `json:"altEntrypoint,omitempty"`
+	LegacyEntryPoint string   `json:"legacyEntrypoint,omitempty"`
+	Size             int64    `json:"size"`
+	UncompressedSize int64    `json:"uncompressedSize"`
+	Signed           bool     `json:"signed"`
+}
+
+// ListOther is a tag which is not a netboot artifact.
+type ListOther struct {
+	Tag    string `json:"tag"`
+	Digest string `json:"digest"`
+	Kind   string `json:"kind"`
+}
+
+type listOutput struct {
+	Artifacts  []ListEntry `json:"artifacts"`
+	Other      []ListOther `json:"other"`
+	OtherCount int         `json:"otherCount"`
 }
 
 func List(ctx context.Context, args ListArgs) {
-	repo, err := remote.NewRepository(args.Source)
-	if err != nil {
-		panic(err)
+	if args.Output != "text" && args.Output != "json" {
+		Fatal("unknown output format", args.Output)
 	}
+	if args.Sort != "tag" && args.Sort != "os" && args.Sort != "version" {
+		Fatal("unknown sort key", args.Sort)
+	}
+
+	repo := newRepository(args.Source, args.Plain)
 
-	repo.Client = &auth.Client{
-		Client: retry.DefaultClient,
-		Cache:  auth.NewCache(),
+	tags := make([]string, 0)
+	err := repo.Tags(ctx, "", func(page []string) error {
+		tags = append(tags, page...)
+		return nil
+	})
+	if err != nil {
+		FatalErr(err, "cannot list tags")
 	}
 
-	err = repo.Tags(ctx, "", func(tags []string) error {
-		for _, tag := range tags {
-			if strings.HasSuffix(tag, ".sig") {
+	out := listOutput{Artifacts: make([]ListEntry, 0), Other: make([]ListOther, 0)}
+	for _, tag := range tags {
+		if isHelperTag(tag) {
+			continue
+		}
+
+		info, err := fetchArtifactInfo(ctx, repo, tag)
+		if err != nil {
+			ErrorErr(err, "cannot fetch", tag)
+			continue
+		}
+
+		if !info.isNetboot() {
+			out.OtherCount++
+			if !args.All {
+				Debug("not a netboot artifact", tag)
 				continue
 			}
+			out.Other = append(out.Other, ListOther{Tag: tag, Digest: info.desc.Digest.String(), Kind: info.kind()})
+			continue
+		}
+
+		entry, err := listEntry(ctx, repo, info)
+		if err != nil {
+			ErrorErr(err, "cannot fetch", tag)
+			continue
+		}
+		entry.Signed = slices.Contains(tags, cosignSignatureTag(info.desc))
+		if !entry.Signed {
+			entry.Signed, err = hasSignatureReferrer(ctx, repo, info.desc)
+			if err != nil {
+				Debugf("cannot list referrers of %s: %v", tag, err)
+			}
+		}
+		out.Artifacts = append(out.Artifacts, *entry)
+	}
+
+	sortListEntries(out.Artifacts, args.Sort)
+
+	if args.Output == "json" {
+		buf, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			FatalErr(err, "cannot marshal output")
+		}
+		Print(string(buf))
+		return
+	}
 
-			Print(tag)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TAG\tOS\tVERSION\tARCH\tENTRYPOINTS\tSIZE\tUNCOMPRESSED\tDIGEST\tSIGNED")
+	for _, e := range out.Artifacts {
+		entrypoints := strings.Join(slices.DeleteFunc([]string{e.EntryPoint, e.AltEntryPoint, e.LegacyEntryPoint}, func(s string) bool { return s == "" }), ",")
+		signed := "no"
+		if e.Signed {
+			signed = "yes"
+		}
+		tag := e.Tag
+		if e.Schema == "legacy" {
+			tag += " (legacy)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", tag, e.Name, e.Version, strings.Join(e.Architectures, ","),
+			entrypoints, humanSize(e.Size), humanSize(e.UncompressedSize), shortDigest(e.Digest), signed)
+	}
+	tw.Flush()
+
+	if len(out.Other) > 0 {
+		Print("")
+		Print("Other tags:")
+		for _, o := range out.Other {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", o.Tag, o.Kind, shortDigest(o.Digest))
+		}
+		tw.Flush()
+	} else if out.OtherCount > 0 {
+		Print("")
+		Printf("%d tags which are not netboot artifacts are hidden, use --all to list them\n", out.OtherCount)
+	}
+}
+
+// listEntry returns list entry of a netboot manifest or index, sizes of index entries are summed.
+func listEntry(ctx context.Context, repo *remote.Repository, info *artifactInfo) (*ListEntry, error) {
+	a := info.annotations
+	entry := &ListEntry{
+		Tag:              info.tag,
+		Digest:           info.desc.Digest.String(),
+		MediaType:        info.desc.MediaType,
+		Schema:           a[AnnotationSchemaVersion],
+		Name:             a[AnnotationOSName],
+		Version:          a[AnnotationOSVersion],
+		EntryPoint:       a[AnnotationEntryPoint],
+		AltEntryPoint:    a[AnnotationAltEntryPoint],
+		LegacyEntryPoint: a[AnnotationLegacyEntryPoint],
+		Architectures:    make([]string, 0),
+	}
+	if info.isLegacy() {
+		entry.Schema = "legacy"
+	}
+
+	if arch := a[AnnotationOSArch]; arch != "" {
+		entry.Architectures = append(entry.Architectures, arch)
+	}
+	entry.Size, entry.UncompressedSize = layersSize(info.layers)
+
+	for _, m := range info.manifests {
+		if m.MediaType != ocispec.MediaTypeImageManifest {
+			continue
+		}
+		entry.Architectures = append(entry.Architectures, descriptorArchitecture(m))
+
+		blob, err := content.FetchAll(ctx, repo, m)
+		if err != nil {
+			return nil, err
+		}
+		var manifest ocispec.Manifest
+		if err := json.Unmarshal(blob, &manifest); err != nil {
+			return nil, err
+		}
+		if entry.EntryPoint == "" {
+			entry.EntryPoint = manifest.Annotations[AnnotationEntryPoint]
+			entry.AltEntryPoint = manifest.Annotations[AnnotationAltEntryPoint]
+			entry.LegacyEntryPoint = manifest.Annotations[AnnotationLegacyEntryPoint]
+		}
+		size, srcSize := layersSize(manifest.Layers)
+		entry.Size += size
+		entry.UncompressedSize += srcSize
+	}
+
+	return entry, nil
+}
+
+// layersSize returns compressed and uncompressed size of netboot files.
+func layersSize(layers []ocispec.Descriptor) (int64, int64) {
+	var size, srcSize int64
+	for _, l := range layers {
+		if l.MediaType != NetbootFileZstdMediaType {
+			continue
+		}
+		size += l.Size
+		s, _ := strconv.ParseInt(l.Annotations[AnnotationSrcSize], 10, 64)
+		srcSize += s
+	}
+
+	return size, srcSize
+}
+
+func sortListEntries(entries []ListEntry, key string) {
+	slices.SortStableFunc(entries, func(a, b ListEntry) int {
+		switch key {
+		case "os":
+			if c := strings.Compare(a.Name, b.Name); c != 0 {
+				return c
+			}
+			if c := CompareVersions(a.Version, b.Version); c != 0 {
+				return c
+			}
+		case "version":
+			if c := CompareVersions(a.Version, b.Version); c != 0 {
+				return c
+			}
+			if c := strings.Compare(a.Name, b.Name); c != 0 {
+				return c
+			}
+		}
+		return strings.Compare(a.Tag, b.Tag)
+	})
+}
+
+// signatureArtifactTypes are artifact types of cosign, sigstore bundle and notation signatures
+// attached as referrers.
+var signatureArtifactTypes = []string{
+	"application/vnd.dev.cosign.artifact.sig.v1+json",
+	"application/vnd.dev.sigstore.bundle.v0.3+json",
+	notationregistry.ArtifactTypeNotation,
+}
+
+// hasSignatureReferrer returns true when a signature is attached to the manifest as a referrer.
+func hasSignatureReferrer(ctx context.Context, repo *remote.Repository, desc ocispec.Descriptor) (bool, error) {
+	found := false
+	err := repo.Referrers(ctx, desc, "", func(referrers []ocispec.Descriptor) error {
+		for _, r := range referrers {
+			artifactType := r.ArtifactType
+			if artifactType == EmptyType || artifactType == "" {
+				// some registries report config media type instead of the artifact type
+				blob, err := content.FetchAll(ctx, repo, r)
+				if err != nil {
+					return err
+				}
+				var manifest ocispec.Manifest
+				if err := json.Unmarshal(blob, &manifest); err != nil {
+					return err
+				}
+				artifactType = manifest.ArtifactType
+			}
+			if slices.Contains(signatureArtifactTypes, artifactType) {
+				found = true
+			}
 		}
 		return nil
 	})
+
+	return found, err
+}
+
+// cosignSignatureTag returns tag of cosign signature of the manifest.
+func cosignSignatureTag(desc ocispec.Descriptor) string {
+	return strings.Replace(desc.Digest.String(), ":", "-", 1) + ".sig"
+}
+
+func shortDigest(d string) string {
+	if _, hex, ok := strings.Cut(d, ":"); ok && len(hex) > 12 {
+		return hex[:12]
+	}
+	return d
+}
+
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// isHelperTag returns true for tags of signatures, attestations and referrers tag schema.
+func isHelperTag(tag string) bool {
+	return strings.HasSuffix(tag, ".sig") || strings.HasSuffix(tag, ".att") ||
+		strings.HasSuffix(tag, ".sbom") || strings.HasPrefix(tag, "sha256-")
+}
+
+// artifactInfo is descriptor, artifact type and annotations of a tagged manifest or index.
+type artifactInfo struct {
+	tag          string
+	desc         ocispec.Descriptor
+	artifactType string
+	annotations  map[string]string
+	config       *ocispec.Descriptor
+	layers       []ocispec.Descriptor
+	manifests    []ocispec.Descriptor
+}
+
+// fetchArtifactInfo resolves a tag and returns artifact type and annotations of the manifest or
+// index without fetching any blobs.
+func fetchArtifactInfo(ctx context.Context, repo *remote.Repository, tag string) (*artifactInfo, error) {
+	desc, err := repo.Resolve(ctx, tag)
 	if err != nil {
-		FatalErr(err, "cannot list tags")
+		return nil, err
+	}
+
+	info := &artifactInfo{tag: tag, desc: desc}
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageManifest, ocispec.MediaTypeImageIndex, dockerManifestMediaType, dockerManifestListMediaType:
+	default:
+		return info, nil
+	}
+
+	blob, err := content.FetchAll(ctx, repo, desc)
+	if err != nil {
+		return nil, err
+	}
+
+	// manifest and index share artifact type and annotations
+	var m struct {
+		ArtifactType string               `json:"artifactType"`
+		Annotations  map[string]string    `json:"annotations"`
+		Config       *ocispec.Descriptor  `json:"config"`
+		Layers       []ocispec.Descriptor `json:"layers"`
+		Manifests    []ocispec.Descriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal(blob, &m); err != nil {
+		return nil, err
+	}
+	info.artifactType = m.ArtifactType
+	info.annotations = m.Annotations
+	info.config = m.Config
+	info.layers = m.Layers
+	info.manifests = m.Manifests
+
+	return info, nil
+}
+
+// isNetboot returns true for netboot manifests and indexes.
+func (i *artifactInfo) isNetboot() bool {
+	return IsNetbootArtifact(i.artifactType, i.annotations)
+}
+
+// isLegacy returns true for netboot manifests and indexes of unknown artifact type.
+func (i *artifactInfo) isLegacy() bool {
+	return IsLegacyArtifact(i.artifactType, i.annotations)
+}
+
+// kind returns human readable kind of an artifact which is not a netboot artifact.
+func (i *artifactInfo) kind() string {
+	switch {
+	case i.artifactType != "":
+		return i.artifactType
+	case i.desc.MediaType == ocispec.MediaTypeImageIndex || i.desc.MediaType == dockerManifestListMediaType:
+		return "image index"
+	case i.config != nil && i.config.MediaType == ocispec.MediaTypeImageConfig:
+		return "container image"
+	case i.config != nil && i.config.MediaType == "application/vnd.docker.container.image.v1+json":
+		return "container image"
+	case i.config != nil:
+		return i.config.MediaType
 	}
+	return i.desc.MediaType
 }
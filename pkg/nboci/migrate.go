@@ -0,0 +1,129 @@
+package nboci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+type MigrateArgs struct {
+	Source string `arg:"positional,required" help:"repository with optional tag" placeholder:"REPOSITORY[:TAG]"`
+	Plain  bool   `arg:"-N,--plain" help:"plain HTTP (insecure)"`
+	DryRun bool   `arg:"-n,--dry-run" help:"only print tags which would be migrated"`
+}
+
+// Migrate rewrites legacy netboot manifests and indexes of unknown artifact type to the current
+// schema in place. Blobs are reused, only manifests are pushed again under the same tags.
+func Migrate(ctx context.Context, args MigrateArgs) {
+	repo := newRepository(args.Source, args.Plain)
+	onlyTag := repo.Reference.Reference
+
+	migrated := 0
+	err := repo.Tags(ctx, "", func(tags []string) error {
+		for _, tag := range tags {
+			if (onlyTag != "" && tag != onlyTag) || isHelperTag(tag) {
+				continue
+			}
+
+			info, err := fetchArtifactInfo(ctx, repo, tag)
+			if err != nil {
+				return err
+			}
+			if !info.isLegacy() {
+				Debug("skipping", tag)
+				continue
+			}
+
+			if args.DryRun {
+				Print("would migrate", tag)
+				continue
+			}
+
+			newDesc, err := migrateDescriptor(ctx, repo, info.desc, tag)
+			if err != nil {
+				return err
+			}
+			Print("migrated", tag, info.desc.Digest.String(), "->", newDesc.Digest.String())
+			migrated++
+		}
+		return nil
+	})
+	if err != nil {
+		FatalErr(err, "cannot migrate", args.Source)
+	}
+
+	if migrated > 0 {
+		Print("signatures of migrated tags refer to old digests, sign them again")
+	}
+}
+
+// migrateDescriptor pushes a migrated manifest or index and returns its descriptor. Index entries
+// are pushed by digest, the top level manifest is pushed under the tag.
+func migrateDescriptor(ctx context.Context, repo *remote.Repository, desc ocispec.Descriptor, tag string) (ocispec.Descriptor, error) {
+	blob, err := content.FetchAll(ctx, repo, desc)
+	if err != nil {
+		return desc, err
+	}
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageManifest:
+		var manifest ocispec.Manifest
+		if err := json.Unmarshal(blob, &manifest); err != nil {
+			return desc, err
+		}
+		if !IsLegacyArtifact(manifest.ArtifactType, manifest.Annotations) {
+			return desc, nil
+		}
+
+		manifest.ArtifactType = NetbootArtifactType
+		manifest.Annotations = baseNameAnnotations(manifest.Annotations)
+		if manifest.Annotations == nil {
+			manifest.Annotations = make(map[string]string)
+		}
+		manifest.Annotations[AnnotationSchemaVersion] = NetbootSchemaVersion
+		blob, err = json.Marshal(manifest)
+	case ocispec.MediaTypeImageIndex:
+		var index ocispec.Index
+		if err := json.Unmarshal(blob, &index); err != nil {
+			return desc, err
+		}
+
+		for i, m := range index.Manifests {
+			if m.MediaType != ocispec.MediaTypeImageManifest {
+				continue
+			}
+			migratedEntry, err := migrateDescriptor(ctx, repo, m, "")
+			if err != nil {
+				return desc, err
+			}
+			m.Digest = migratedEntry.Digest
+			m.Size = migratedEntry.Size
+			m.ArtifactType = NetbootArtifactType
+			index.Manifests[i] = m
+		}
+
+		index.ArtifactType = NetbootArtifactType
+		if index.Annotations == nil {
+			index.Annotations = make(map[string]string)
+		}
+		index.Annotations[AnnotationSchemaVersion] = NetbootSchemaVersion
+		blob, err = json.Marshal(index)
+	default:
+		return desc, nil
+	}
+	if err != nil {
+		return desc, err
+	}
+
+	newDesc := content.NewDescriptorFromBytes(desc.MediaType, blob)
+	if tag == "" {
+		err = repo.Push(ctx, newDesc, bytes.NewReader(blob))
+	} else {
+		err = repo.PushReference(ctx, newDesc, bytes.NewReader(blob), tag)
+	}
+	return newDesc, err
+}
@@ -0,0 +1,268 @@
+package nboci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+)
+
+// File roles in netboot config.
+const (
+	RoleEntryPoint       = "entrypoint"
+	RoleAltEntryPoint    = "alt-entrypoint"
+	RoleLegacyEntryPoint = "legacy-entrypoint"
+	RoleKernel           = "kernel"
+	RoleInitrd           = "initrd"
+)
+
+// NetbootConfig is the config blob of netboot artifacts. Manifest annotations carry the same
+// information in flat form for registries and older clients.
+type NetbootConfig struct {
+	SchemaVersion string              `json:"schemaVersion"`
+	OS            NetbootOS           `json:"os"`
+	EntryPoints   NetbootEntryPoints  `json:"entrypoints"`
+	Kernel        *NetbootKernel      `json:"kernel,omitempty"`
+	Files         []NetbootConfigFile `json:"files"`
+}
+
+type NetbootOS struct {
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	Architecture string `json:"architecture"`
+}
+
+// NetbootEntryPoints are bootloader file names per firmware mode: the default one (e.g. shim for
+// UEFI with SecureBoot), the alternative one (e.g. grub for UEFI without SecureBoot) and the
+// legacy one (e.g. pxelinux for BIOS).
+type NetbootEntryPoints struct {
+	Default   string `json:"default"`
+	Alternate string `json:"alternate,omitempty"`
+	Legacy    string `json:"legacy,omitempty"`
+}
+
+type NetbootKernel struct {
+	File    string `json:"file"`
+	Initrd  string `json:"initrd,omitempty"`
+	Args    string `json:"args,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+type NetbootConfigFile struct {
+	Name    string   `json:"name"`
+	Roles   []string `json:"roles,omitempty"`
+	Digest  string   `json:"digest,omitempty"`
+	Size    int64    `json:"size,omitempty"`
+	Package string   `json:"package,omitempty"`
+}
+
+// newNetbootConfig creates netboot config from push arguments and pushed layers.
+func newNetbootConfig(args PushArgs, layers []ocispec.Descriptor) *NetbootConfig {
+	c := &NetbootConfig{
+		SchemaVersion: NetbootSchemaVersion,
+		OS: NetbootOS{
+			Name:         args.Name,
+			Version:      args.Version,
+			Architecture: args.Architecture,
+		},
+		EntryPoints: NetbootEntryPoints{
+			Default:   args.EntryPoint,
+			Alternate: args.AltEntryPoint,
+			Legacy:    args.LegacyEntryPoint,
+		},
+		Files: make([]NetbootConfigFile, 0, len(layers)),
+	}
+
+	if args.Kernel != "" {
+		c.Kernel = &NetbootKernel{
+			File:    args.Kernel,
+			Initrd:  args.Initrd,
+			Args:    args.KernelArgs,
+			Version: args.KernelVersion,
+		}
+	}
+
+	for _, l := range layers {
+		size, _ := strconv.ParseInt(l.Annotations[AnnotationSrcSize], 10, 64)
+		c.Files = append(c.Files, NetbootConfigFile{
+			Name:    l.Annotations[AnnotationTitle],
+			Digest:  l.Annotations[AnnotationSrcDigest],
+			Size:    size,
+			Package: l.Annotations[AnnotationSrcRPM],
+		})
+	}
+	c.assignRoles()
+
+	return c
+}
+
+// legacyNameAnnotations hold file names which legacy clients stored as given on the command
+// line, possibly with a directory.
+var legacyNameAnnotations = []string{
+	AnnotationEntryPoint,
+	AnnotationAltEntryPoint,
+	AnnotationLegacyEntryPoint,
+	AnnotationKernel,
+	AnnotationInitrd,
+}
+
+// baseNameAnnotations returns a copy of annotations with legacy file names reduced to base names.
+func baseNameAnnotations(annotations map[string]string) map[string]string {
+	a := maps.Clone(annotations)
+	for _, key := range legacyNameAnnotations {
+		if a[key] != "" {
+			a[key] = filepath.Base(a[key])
+		}
+	}
+	return a
+}
+
+// netbootConfigFromManifest creates netboot config from annotations of manifests without
+// config blob.
+func netbootConfigFromManifest(manifest *ocispec.Manifest) *NetbootConfig {
+	a := baseNameAnnotations(manifest.Annotations)
+	c := &NetbootConfig{
+		SchemaVersion: a[AnnotationSchemaVersion],
+		OS: NetbootOS{
+			Name:         a[AnnotationOSName],
+			Version:      a[AnnotationOSVersion],
+			Architecture: a[AnnotationOSArch],
+		},
+		EntryPoints: NetbootEntryPoints{
+			Default:   a[AnnotationEntryPoint],
+			Alternate: a[AnnotationAltEntryPoint],
+			Legacy:    a[AnnotationLegacyEntryPoint],
+		},
+		Files: make([]NetbootConfigFile, 0, len(manifest.Layers)),
+	}
+
+	if a[AnnotationKernel] != "" {
+		c.Kernel = &NetbootKernel{
+			File:    a[AnnotationKernel],
+			Initrd:  a[AnnotationInitrd],
+			Args:    a[AnnotationKernelArgs],
+			Version: a[AnnotationKernelVersion],
+		}
+	}
+
+	for _, l := range manifest.Layers {
+		if l.MediaType != NetbootFileZstdMediaType {
+			continue
+		}
+		size, _ := strconv.ParseInt(l.Annotations[AnnotationSrcSize], 10, 64)
+		c.Files = append(c.Files, NetbootConfigFile{
+			Name:    l.Annotations[AnnotationTitle],
+			Digest:  l.Annotations[AnnotationSrcDigest],
+			Size:    size,
+			Package: l.Annotations[AnnotationSrcRPM],
+		})
+	}
+	c.assignRoles()
+
+	return c
+}
+
+func (c *NetbootConfig) assignRoles() {
+	roles := map[string]string{
+		RoleEntryPoint:       c.EntryPoints.Default,
+		RoleAltEntryPoint:    c.EntryPoints.Alternate,
+		RoleLegacyEntryPoint: c.EntryPoints.Legacy,
+	}
+	if c.Kernel != nil {
+		roles[RoleKernel] = c.Kernel.File
+		roles[RoleInitrd] = c.Kernel.Initrd
+	}
+
+	for i := range c.Files {
+		c.Files[i].Roles = nil
+		for _, role := range []string{RoleEntryPoint, RoleAltEntryPoint, RoleLegacyEntryPoint, RoleKernel, RoleInitrd} {
+			if roles[role] != "" && filepath.Base(roles[role]) == c.Files[i].Name {
+				c.Files[i].Roles = append(c.Files[i].Roles, role)
+			}
+		}
+	}
+}
+
+// Path returns relative destination path of the artifact (name/version/arch).
+func (c *NetbootConfig) Path() (string, error) {
+	if c.OS.Name == "" || c.OS.Version == "" || c.OS.Architecture == "" {
+		return "", errors.New("missing OS name, version or architecture")
+	}
+	if err := c.validate(); err != nil {
+		return "", err
+	}
+
+	return path.Join(c.OS.Name, c.OS.Version, c.OS.Architecture), nil
+}
+
+// validPathElement returns true for names which can be used as a single element of a path
+// inside the destination directory.
+func validPathElement(name string) bool {
+	return name != "" && name != "." && name != ".." && filepath.Base(name) == name && path.Base(name) == name
+}
+
+// validate checks OS and file names of a config which comes from the registry, so it never
+// points outside of the destination directory. Empty names are allowed, Path checks the OS.
+func (c *NetbootConfig) validate() error {
+	for _, v := range []string{c.OS.Name, c.OS.Version} {
+		if v != "" && (!AlphanumRegexp.MatchString(v) || !validPathElement(v)) {
+			return fmt.Errorf("invalid OS name or version %q", v)
+		}
+	}
+	if c.OS.Architecture != "" && !ArchRegexp.MatchString(c.OS.Architecture) {
+		return fmt.Errorf("invalid architecture %q", c.OS.Architecture)
+	}
+
+	names := []string{c.EntryPoints.Default, c.EntryPoints.Alternate, c.EntryPoints.Legacy}
+	if c.Kernel != nil {
+		names = append(names, c.Kernel.File, c.Kernel.Initrd)
+	}
+	for _, f := range c.Files {
+		if !validPathElement(f.Name) {
+			return fmt.Errorf("invalid file name %q", f.Name)
+		}
+	}
+	for _, n := range names {
+		if n != "" && !validPathElement(n) {
+			return fmt.Errorf("invalid file name %q", n)
+		}
+	}
+
+	return nil
+}
+
+// loadNetbootConfig returns validated netboot config from the config blob or from manifest
+// annotations when the manifest has no netboot config.
+func loadNetbootConfig(ctx context.Context, fetcher content.Fetcher, manifest *ocispec.Manifest) (*NetbootConfig, error) {
+	if manifest.Config.MediaType != NetbootConfigMediaType {
+		c := netbootConfigFromManifest(manifest)
+		if err := c.validate(); err != nil {
+			return nil, fmt.Errorf("invalid netboot annotations: %w", err)
+		}
+		return c, nil
+	}
+
+	blob, err := content.FetchAll(ctx, fetcher, manifest.Config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch netboot config: %w", err)
+	}
+	var c NetbootConfig
+	if err := json.Unmarshal(blob, &c); err != nil {
+		return nil, fmt.Errorf("cannot parse netboot config: %w", err)
+	}
+	if c.SchemaVersion != NetbootSchemaVersion {
+		return nil, fmt.Errorf("unsupported netboot config schema version %q", c.SchemaVersion)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid netboot config: %w", err)
+	}
+
+	return &c, nil
+}
@@ -0,0 +1,49 @@
+package nboci
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestNetbootConfigFromLegacyManifest(t *testing.T) {
+	layer := func(name string) ocispec.Descriptor {
+		return ocispec.Descriptor{
+			MediaType:   NetbootFileZstdMediaType,
+			Annotations: map[string]string{AnnotationTitle: name, AnnotationSrcSize: "4"},
+		}
+	}
+	manifest := &ocispec.Manifest{
+		Annotations: map[string]string{
+			AnnotationOSName:        "fedora",
+			AnnotationOSVersion:     "41",
+			AnnotationOSArch:        "x86_64",
+			AnnotationEntryPoint:    "/srv/shim/shimx64.efi",
+			AnnotationAltEntryPoint: "grubx64.efi",
+			AnnotationKernel:        "images/pxeboot/vmlinuz",
+			AnnotationInitrd:        "images/pxeboot/initrd.img",
+		},
+		Layers: []ocispec.Descriptor{layer("shimx64.efi"), layer("grubx64.efi"), layer("vmlinuz"), layer("initrd.img")},
+	}
+
+	c, err := loadNetbootConfig(context.Background(), nil, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.EntryPoints.Default != "shimx64.efi" || c.Kernel.File != "vmlinuz" || c.Kernel.Initrd != "initrd.img" {
+		t.Fatalf("unexpected names %+v %+v", c.EntryPoints, c.Kernel)
+	}
+	if !slices.Contains(c.Files[0].Roles, RoleEntryPoint) || !slices.Contains(c.Files[3].Roles, RoleInitrd) {
+		t.Fatalf("unexpected roles %+v", c.Files)
+	}
+	if manifest.Annotations[AnnotationEntryPoint] != "/srv/shim/shimx64.efi" {
+		t.Fatal("manifest annotations were modified")
+	}
+
+	manifest.Annotations[AnnotationEntryPoint] = "/srv/shim/.."
+	if _, err := loadNetbootConfig(context.Background(), nil, manifest); err == nil {
+		t.Fatal("entry point outside of the directory accepted")
+	}
+}
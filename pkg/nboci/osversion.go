@@ -0,0 +1,145 @@
+package nboci
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// versionConstraint is a version glob or comparison with an operator.
+type versionConstraint struct {
+	op      string
+	version string
+}
+
+// VersionFilter is a comma separated list of constraints which all must match, each constraint
+// is either a glob (9.*) or a comparison (>=9.2, <10, =9.3.0).
+type VersionFilter []versionConstraint
+
+// ParseVersionFilter parses and validates a version filter, empty filter matches all versions.
+func ParseVersionFilter(filter string) (VersionFilter, error) {
+	result := make(VersionFilter, 0)
+	if strings.TrimSpace(filter) == "" {
+		return result, nil
+	}
+
+	for _, c := range strings.Split(filter, ",") {
+		c = strings.TrimSpace(c)
+		op := ""
+		for _, o := range []string{">=", "<=", "==", "!=", ">", "<", "="} {
+			if strings.HasPrefix(c, o) {
+				op = o
+				break
+			}
+		}
+		v := strings.TrimSpace(strings.TrimPrefix(c, op))
+		if v == "" {
+			return nil, fmt.Errorf("missing version in %q", c)
+		}
+		if op == "" {
+			if _, err := path.Match(v, ""); err != nil {
+				return nil, fmt.Errorf("invalid version pattern %q: %w", v, err)
+			}
+		} else if strings.ContainsAny(v, "*?[") {
+			return nil, errors.New("version patterns cannot be compared: " + c)
+		}
+		result = append(result, versionConstraint{op: op, version: v})
+	}
+
+	return result, nil
+}
+
+// Match returns true when the version matches all constraints.
+func (f VersionFilter) Match(version string) bool {
+	for _, c := range f {
+		cmp := CompareVersions(version, c.version)
+		var ok bool
+		switch c.op {
+		case "":
+			ok, _ = path.Match(c.version, version)
+		case ">=":
+			ok = cmp >= 0
+		case "<=":
+			ok = cmp <= 0
+		case ">":
+			ok = cmp > 0
+		case "<":
+			ok = cmp < 0
+		case "=", "==":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CompareVersions compares versions segment by segment, numeric segments are compared as
+// numbers (9.10 is after 9.9) and are newer than alphabetic ones. Returns -1, 0 or 1.
+func CompareVersions(a, b string) int {
+	as, bs := versionSegments(a), versionSegments(b)
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.ParseUint(as[i], 10, 64)
+		bn, berr := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case aerr == nil && berr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aerr == nil:
+			return 1
+		case berr == nil:
+			return -1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// versionSegments splits version into runs of digits and letters, other characters separate
+// segments.
+func versionSegments(v string) []string {
+	result := make([]string, 0)
+	current := strings.Builder{}
+	digit := false
+	for _, r := range v {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if current.Len() > 0 {
+				result = append(result, current.String())
+				current.Reset()
+			}
+			continue
+		}
+		if current.Len() > 0 && unicode.IsDigit(r) != digit {
+			result = append(result, current.String())
+			current.Reset()
+		}
+		digit = unicode.IsDigit(r)
+		current.WriteRune(r)
+	}
+	if current.Len() > 0 {
+		result = append(result, current.String())
+	}
+
+	return result
+}
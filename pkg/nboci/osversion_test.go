@@ -0,0 +1,75 @@
+package nboci
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"9", "9", 0},
+		{"9.10", "9.9", 1},
+		{"9.2", "9.10", -1},
+		{"9", "9.0", -1},
+		{"9.0.1", "9.0", 1},
+		{"10", "9.99", 1},
+		{"41", "41", 0},
+		{"9.4", "9-4", 0},
+		{"24.04", "24.4", 0},
+		{"9.4a", "9.4", 1},
+		{"9.4", "9.4beta", -1},
+		{"9.alpha", "9.beta", -1},
+		{"9.1", "9.beta", 1},
+		{"rawhide", "41", -1},
+		{"12.1", "12.01", 0},
+	}
+	for _, tt := range tests {
+		if c := CompareVersions(tt.a, tt.b); c != tt.expected {
+			t.Errorf("CompareVersions(%q, %q) = %d, expected %d", tt.a, tt.b, c, tt.expected)
+		}
+		if c := CompareVersions(tt.b, tt.a); c != -tt.expected {
+			t.Errorf("CompareVersions(%q, %q) = %d, expected %d", tt.b, tt.a, c, -tt.expected)
+		}
+	}
+}
+
+func TestVersionFilter(t *testing.T) {
+	tests := []struct {
+		filter   string
+		version  string
+		expected bool
+	}{
+		{"", "9.4", true},
+		{"9.*", "9.4", true},
+		{"9.*", "10.0", false},
+		{">=9.2,<10", "9.10", true},
+		{">=9.2,<10", "9.1", false},
+		{">=9.2,<10", "10", false},
+		{">= 9.2 , < 10", "9.2", true},
+		{"=9.3.0", "9.3.0", true},
+		{"==9.3", "9.3.0", false},
+		{"!=41", "40", true},
+		{"!=41", "41", false},
+		{">40", "41", true},
+		{"<=40", "41", false},
+		{"4*,>=41", "42", true},
+		{"4*,>=41", "40", false},
+	}
+	for _, tt := range tests {
+		f, err := ParseVersionFilter(tt.filter)
+		if err != nil {
+			t.Fatalf("filter %q: %v", tt.filter, err)
+		}
+		if m := f.Match(tt.version); m != tt.expected {
+			t.Errorf("filter %q on %s = %v, expected %v", tt.filter, tt.version, m, tt.expected)
+		}
+	}
+}
+
+func TestParseVersionFilterErrors(t *testing.T) {
+	for _, filter := range []string{">=", "9.2,", ">9.*", "9.[", "<=[1-2]"} {
+		if _, err := ParseVersionFilter(filter); err == nil {
+			t.Errorf("expected error for filter %q", filter)
+		}
+	}
+}
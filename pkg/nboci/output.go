@@ -38,7 +38,7 @@ func Print(messages ...string) {
 }
 
 func Printf(format string, args ...any) {
-	fmt.Printf(format, args)
+	fmt.Printf(format, args...)
 }
 
 func Error(messages ...string) {
@@ -46,7 +46,7 @@ func Error(messages ...string) {
 }
 
 func Errorf(format string, args ...any) {
-	msg := fmt.Sprintf(format, args)
+	msg := fmt.Sprintf(format, args...)
 	fmt.Fprintf(os.Stderr, "%s\n", msg)
 }
 
@@ -60,7 +60,7 @@ func Fatal(messages ...string) {
 }
 
 func Fatalf(format string, args ...any) {
-	msg := fmt.Sprintf(format, args)
+	msg := fmt.Sprintf(format, args...)
 	fmt.Fprintf(os.Stderr, "%s\n", msg)
 	os.Exit(1)
 }
@@ -71,7 +71,7 @@ func FatalErr(err error, messages ...string) {
 }
 
 func FatalfErr(err error, format string, args ...any) {
-	msg := fmt.Sprintf(format, args)
+	msg := fmt.Sprintf(format, args...)
 	fmt.Fprintf(os.Stderr, "%s: %s\n", msg, err.Error())
 	os.Exit(1)
 }
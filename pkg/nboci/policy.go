@@ -0,0 +1,323 @@
+package nboci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+// Verification policy modes.
+const (
+	// PolicyEnforce aborts pull when an artifact is not verified.
+	PolicyEnforce = "enforce"
+
+	// PolicyWarn prints a warning and pulls artifacts which are not verified.
+	PolicyWarn = "warn"
+
+	// PolicySkip skips artifacts which are not verified and continues with the others.
+	PolicySkip = "skip"
+)
+
+// VerificationPolicy maps repositories and OS names to signatures they require. Rules are
+// evaluated in order and the first matching rule decides.
+type VerificationPolicy struct {
+	Rules []PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule requires a signature verified by any of the verifiers for artifacts of matching
+// repositories and OS names, and attestations of predicate types signed the same way. Only a
+// rule with AllowUnsigned admits artifacts without verification.
+type PolicyRule struct {
+	Name          string           `yaml:"name"`
+	Repositories  []string         `yaml:"repositories"`
+	OS            []string         `yaml:"os"`
+	Mode          string           `yaml:"mode"`
+	AllowUnsigned bool             `yaml:"allow-unsigned"`
+	Verifiers     []VerifierConfig `yaml:"verifiers"`
+	Attestations  []string         `yaml:"require-attestations"`
+
+	verifiers []Verifier
+}
+
+// VerifierConfig is a cosign key, cosign keyless identity or notation trust policy, fields
+// correspond to pull arguments.
+type VerifierConfig struct {
+	SignatureKey                string `yaml:"signature-key"`
+	CertificateIdentity         string `yaml:"certificate-identity"`
+	CertificateIdentityRegexp   string `yaml:"certificate-identity-regexp"`
+	CertificateOIDCIssuer       string `yaml:"certificate-oidc-issuer"`
+	CertificateOIDCIssuerRegexp string `yaml:"certificate-oidc-issuer-regexp"`
+	CertificateChain            string `yaml:"certificate-chain"`
+	IgnoreTlog                  bool   `yaml:"insecure-ignore-tlog"`
+	IgnoreSCT                   bool   `yaml:"insecure-ignore-sct"`
+	Offline                     bool   `yaml:"offline"`
+	RekorPublicKey              string `yaml:"rekor-public-key"`
+	CTLogPublicKey              string `yaml:"ctlog-public-key"`
+	NotationTrustPolicy         string `yaml:"notation-trust-policy"`
+	NotationTrustStore          string `yaml:"notation-trust-store"`
+}
+
+// hasVerifier returns true when the config selects signature key, keyless identity or notation
+// trust policy, other fields only tune verification.
+func (c VerifierConfig) hasVerifier() bool {
+	return c.SignatureKey != "" || c.CertificateIdentity != "" || c.CertificateIdentityRegexp != "" ||
+		c.CertificateOIDCIssuer != "" || c.CertificateOIDCIssuerRegexp != "" || c.CertificateChain != "" ||
+		c.NotationTrustPolicy != "" || c.NotationTrustStore != ""
+}
+
+// verifierConfig returns verification options of pull arguments.
+func (args PullArgs) verifierConfig() VerifierConfig {
+	return VerifierConfig{
+		SignatureKey:                args.SignatureKey,
+		CertificateIdentity:         args.CertificateIdentity,
+		CertificateIdentityRegexp:   args.CertificateIdentityRegexp,
+		CertificateOIDCIssuer:       args.CertificateOIDCIssuer,
+		CertificateOIDCIssuerRegexp: args.CertificateOIDCIssuerRegexp,
+		CertificateChain:            args.CertificateChain,
+		IgnoreTlog:                  args.IgnoreTlog,
+		IgnoreSCT:                   args.IgnoreSCT,
+		Offline:                     args.Offline,
+		RekorPublicKey:              args.RekorPublicKey,
+		CTLogPublicKey:              args.CTLogPublicKey,
+		NotationTrustPolicy:         args.NotationTrustPolicy,
+		NotationTrustStore:          args.NotationTrustStore,
+	}
+}
+
+// apply sets verification pull arguments, relative paths are relative to base. Offline mode and
+// transparency log keys of pull arguments are kept unless set.
+func (c VerifierConfig) apply(pa *PullArgs, base string) {
+	pa.SignatureKey = releasePath(base, c.SignatureKey)
+	pa.CertificateIdentity = c.CertificateIdentity
+	pa.CertificateIdentityRegexp = c.CertificateIdentityRegexp
+	pa.CertificateOIDCIssuer = c.CertificateOIDCIssuer
+	pa.CertificateOIDCIssuerRegexp = c.CertificateOIDCIssuerRegexp
+	pa.CertificateChain = releasePath(base, c.CertificateChain)
+	pa.IgnoreTlog = c.IgnoreTlog
+	pa.IgnoreSCT = c.IgnoreSCT
+	pa.NotationTrustPolicy = releasePath(base, c.NotationTrustPolicy)
+	pa.NotationTrustStore = releasePath(base, c.NotationTrustStore)
+	c.applyLog(pa, base)
+}
+
+// applyLog sets offline mode and transparency log keys when they are set, and adds insecure
+// transparency log options to verification options already in pull arguments.
+func (c VerifierConfig) applyLog(pa *PullArgs, base string) {
+	pa.IgnoreTlog = pa.IgnoreTlog || c.IgnoreTlog
+	pa.IgnoreSCT = pa.IgnoreSCT || c.IgnoreSCT
+	pa.Offline = pa.Offline || c.Offline
+	if c.RekorPublicKey != "" {
+		pa.RekorPublicKey = releasePath(base, c.RekorPublicKey)
+	}
+	if c.CTLogPublicKey != "" {
+		pa.CTLogPublicKey = releasePath(base, c.CTLogPublicKey)
+	}
+}
+
+// loadVerificationPolicy reads and validates a policy file and creates verifiers of all rules.
+// Relative paths are relative to the policy file, plain HTTP, offline mode and transparency log
+// keys are taken from pull arguments.
+func loadVerificationPolicy(filename string, args PullArgs) (*VerificationPolicy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy VerificationPolicy
+	if err := decodeYAML(data, &policy); err != nil {
+		return nil, err
+	}
+	if len(policy.Rules) == 0 {
+		return nil, errors.New("no rules in verification policy")
+	}
+
+	base := filepath.Dir(filename)
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("#%d", i+1)
+		}
+		if err := rule.init(base, args); err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+	}
+
+	return &policy, nil
+}
+
+// init validates the rule and creates its verifiers.
+func (r *PolicyRule) init(base string, args PullArgs) error {
+	switch r.Mode {
+	case "":
+		r.Mode = PolicyEnforce
+	case PolicyEnforce, PolicyWarn, PolicySkip:
+	default:
+		return fmt.Errorf("unknown mode %s", r.Mode)
+	}
+	if len(r.Repositories) == 0 {
+		return errors.New("no repositories")
+	}
+	for _, p := range append(r.Repositories, r.OS...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %s: %w", p, err)
+		}
+	}
+
+	for i, c := range r.Verifiers {
+		pa := PullArgs{
+			Plain:          args.Plain,
+			Offline:        args.Offline,
+			RekorURL:       args.RekorURL,
+			RekorPublicKey: args.RekorPublicKey,
+			CTLogPublicKey: args.CTLogPublicKey,
+		}
+		c.apply(&pa, base)
+		if err := pa.checkVerification(); err != nil {
+			return fmt.Errorf("verifier #%d: %w", i+1, err)
+		}
+		v, err := newVerifier(pa)
+		if err != nil {
+			return fmt.Errorf("verifier #%d: %w", i+1, err)
+		}
+		if v == nil {
+			return fmt.Errorf("verifier #%d: no signature key, certificate identity or notation trust policy", i+1)
+		}
+		r.verifiers = append(r.verifiers, v)
+	}
+
+	// an empty verifier list is a typo rather than a request to pull unsigned artifacts
+	switch {
+	case r.AllowUnsigned && len(r.verifiers) > 0:
+		return errors.New("allow-unsigned and verifiers are mutually exclusive")
+	case r.AllowUnsigned && r.Mode != PolicyEnforce:
+		return errors.New("allow-unsigned rule has no verifiers to enforce, warn or skip")
+	case r.AllowUnsigned && len(r.OS) > 0:
+		// OS name annotation of an unsigned artifact can be set to anything by the pusher
+		return errors.New("allow-unsigned rule cannot match OS names which are not verified")
+	case !r.AllowUnsigned && len(r.verifiers) == 0:
+		return errors.New("no verifiers, use allow-unsigned to admit artifacts without verification")
+	}
+	if len(r.Attestations) > 0 && !slices.ContainsFunc(r.verifiers, func(v Verifier) bool {
+		_, ok := v.(attestationVerifier)
+		return ok
+	}) {
+		return errors.New("required attestations need cosign verifiers")
+	}
+	for _, t := range r.Attestations {
+		if _, err := predicateType(t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// match returns the first rule matching repository (registry/repository) and OS name, or nil.
+// OS name comes from annotations before the signature is verified and can select a rule with
+// weaker verifiers, checkRule rejects artifacts whose verified OS name selects another rule.
+func (p *VerificationPolicy) match(repository, osName string) *PolicyRule {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if !matchAny(repository, rule.Repositories) {
+			continue
+		}
+		if len(rule.OS) > 0 && !matchAny(osName, rule.OS) {
+			continue
+		}
+		return rule
+	}
+
+	return nil
+}
+
+// ruleOf returns the rule which admits the artifact, or nil without a policy.
+func (p *VerificationPolicy) ruleOf(repo *remote.Repository, info *artifactInfo) *PolicyRule {
+	if p == nil {
+		return nil
+	}
+	return p.match(repo.Reference.Registry+"/"+repo.Reference.Repository, info.annotations[AnnotationOSName])
+}
+
+// checkRule returns an error when an OS name of the verified manifest or its config selects
+// another rule than the one which admitted the artifact. Empty names are not checked.
+func (p *VerificationPolicy) checkRule(repo *remote.Repository, rule *PolicyRule, osNames ...string) error {
+	if p == nil {
+		return nil
+	}
+	for _, osName := range osNames {
+		if osName == "" {
+			continue
+		}
+		if other := p.match(repo.Reference.Registry+"/"+repo.Reference.Repository, osName); other != rule {
+			return fmt.Errorf("OS %s is not admitted by rule %s which was selected by unverified annotations", osName, ruleName(rule))
+		}
+	}
+
+	return nil
+}
+
+func ruleName(rule *PolicyRule) string {
+	if rule == nil {
+		return "(none)"
+	}
+	return rule.Name
+}
+
+// admit verifies an artifact according to the first matching rule and returns true when it
+// should be pulled. An error is returned when the artifact is rejected by an enforcing rule or
+// no rule matches.
+func (p *VerificationPolicy) admit(ctx context.Context, repo *remote.Repository, info *artifactInfo) (*VerificationResult, bool, error) {
+	ref := reference(repo, info.tag)
+	osName := info.annotations[AnnotationOSName]
+	rule := p.ruleOf(repo, info)
+	if rule == nil {
+		return nil, false, fmt.Errorf("%s (%s) is not admitted by any policy rule", ref, osName)
+	}
+	if rule.AllowUnsigned {
+		Print("admitted", ref, "by rule", rule.Name, "without verification")
+		return nil, true, nil
+	}
+
+	errs := make([]error, 0, len(rule.verifiers))
+	for _, v := range rule.verifiers {
+		result, err := v.Verify(ctx, repo, info.desc)
+		if err != nil {
+			Debug("verifier of rule", rule.Name, "failed:", err.Error())
+			errs = append(errs, err)
+			continue
+		}
+		result.Tag = info.tag
+		result.Rule = rule.Name
+		Printf("verified %s (%s) signed by %s using %s, admitted by rule %s\n", ref, shortDigest(result.Digest), result.Signer, result.Verifier, rule.Name)
+		return result, true, nil
+	}
+
+	err := errors.Join(errs...)
+	switch rule.Mode {
+	case PolicyWarn:
+		ErrorErr(err, "pulling unverified", ref, "admitted by rule", rule.Name, "in warn mode")
+		return nil, true, nil
+	case PolicySkip:
+		Print("skipping unverified", ref, "by rule", rule.Name)
+		Debug(err.Error())
+		return nil, false, nil
+	}
+
+	return nil, false, fmt.Errorf("rejected by rule %s: %w", rule.Name, err)
+}
+
+// matchAny returns true when the name matches one of shell patterns.
+func matchAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
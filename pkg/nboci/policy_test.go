@@ -0,0 +1,164 @@
+package nboci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+)
+
+func TestLoadVerificationPolicy(t *testing.T) {
+	_, keyFile := generateTestKey(t)
+	tests := []struct {
+		name   string
+		policy string
+		err    string
+	}{
+		{"valid", fmt.Sprintf(`rules:
+  - name: rhel
+    repositories: ["registry.example.com/*"]
+    os: [rhel]
+    verifiers: [{signature-key: %s}]
+  - repositories: ["*/*"]
+    allow-unsigned: true
+`, keyFile), ""},
+		{"no rules", "rules: []\n", "no rules"},
+		{"unknown key", "rules:\n  - repository: [x]\n", "field repository not found"},
+		{"unknown mode", "rules:\n  - repositories: [x]\n    mode: audit\n    allow-unsigned: true\n", "unknown mode audit"},
+		{"no repositories", "rules:\n  - allow-unsigned: true\n", "no repositories"},
+		{"no verifiers", "rules:\n  - repositories: [x]\n", "use allow-unsigned"},
+		{"allow-unsigned with os", "rules:\n  - repositories: [x]\n    os: [fedora]\n    allow-unsigned: true\n", "cannot match OS names"},
+		{"allow-unsigned in warn mode", "rules:\n  - repositories: [x]\n    mode: warn\n    allow-unsigned: true\n", "no verifiers to enforce"},
+		{"allow-unsigned with verifiers", fmt.Sprintf("rules:\n  - repositories: [x]\n    allow-unsigned: true\n    verifiers: [{signature-key: %s}]\n", keyFile), "mutually exclusive"},
+		{"invalid pattern", "rules:\n  - repositories: [\"[\"]\n    allow-unsigned: true\n", "invalid pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := loadVerificationPolicy(writeTestFile(t, "policy.yaml", []byte(tt.policy)), PullArgs{})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r := policy.match("registry.example.com/netboot", "rhel"); r == nil || r.Name != "rhel" {
+				t.Fatalf("matched rule %v", r)
+			}
+			if r := policy.match("registry.example.com/netboot", "fedora"); r == nil || r.Name != "#2" {
+				t.Fatalf("matched rule %v", r)
+			}
+		})
+	}
+}
+
+// TestPolicyVerifiedOS pushes a rhel artifact labeled as fedora in the manifest annotations and
+// signed by the key of the fedora rule, it must not be admitted by the weaker rule.
+func TestPolicyVerifiedOS(t *testing.T) {
+	ctx := context.Background()
+	img := pushTestImage(t)
+	repository := img.repo.Reference.Registry + "/" + img.repo.Reference.Repository
+	rhelKey, rhelKeyFile := generateTestKey(t)
+	fedoraKey, fedoraKeyFile := generateTestKey(t)
+
+	args := PushArgs{
+		Repository:    repository,
+		Plain:         true,
+		Name:          "rhel",
+		Version:       "9.4",
+		Architecture:  "x86_64",
+		EntryPoint:    "shim.efi",
+		NetbootConfig: true,
+		File:          []string{writeTestFile(t, "shim.efi", []byte("shim"))},
+	}
+	desc, err := preparePush(ctx, &args, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pushArtifact(ctx, args, desc); err != nil {
+		t.Fatal(err)
+	}
+
+	// relabel the manifest, the config blob still says rhel
+	desc, err = img.repo.Resolve(ctx, args.Tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := content.FetchAll(ctx, img.repo, desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(blob, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	manifest.Annotations[AnnotationOSName] = "fedora"
+	if blob, err = json.Marshal(manifest); err != nil {
+		t.Fatal(err)
+	}
+	desc = content.NewDescriptorFromBytes(ocispec.MediaTypeImageManifest, blob)
+	if err := img.repo.PushReference(ctx, desc, bytes.NewReader(blob), args.Tag); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := writeTestFile(t, "policy.yaml", []byte(fmt.Sprintf(`rules:
+  - name: rhel
+    repositories: ["*/*"]
+    os: [rhel]
+    verifiers: [{signature-key: %s, insecure-ignore-tlog: true}]
+  - name: fedora
+    repositories: ["*/*"]
+    verifiers: [{signature-key: %s, insecure-ignore-tlog: true}]
+`, rhelKeyFile, fedoraKeyFile)))
+	pullArgs := PullArgs{Source: repository + ":" + args.Tag, Plain: true, VerificationPolicy: policy}
+
+	relabeled := &testImage{repo: img.repo, desc: desc}
+	relabeled.digest, err = name.NewDigest(repository+"@"+desc.Digest.String(), name.Insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relabeled.sign(t, fedoraKey)
+	pullArgs.Destination = t.TempDir()
+	if err := pull(ctx, pullArgs); err == nil || !strings.Contains(err.Error(), "OS rhel is not admitted by rule fedora") {
+		t.Fatalf("expected rule mismatch, got %v", err)
+	}
+
+	// signed by the key of the rhel rule, but the label still selects the fedora rule
+	relabeled.sign(t, rhelKey)
+	pullArgs.Destination = t.TempDir()
+	if err := pull(ctx, pullArgs); err == nil || !strings.Contains(err.Error(), "OS rhel is not admitted by rule fedora") {
+		t.Fatalf("expected rule mismatch, got %v", err)
+	}
+
+	// correctly labeled artifact
+	manifest.Annotations[AnnotationOSName] = "rhel"
+	if blob, err = json.Marshal(manifest); err != nil {
+		t.Fatal(err)
+	}
+	desc = content.NewDescriptorFromBytes(ocispec.MediaTypeImageManifest, blob)
+	if err := img.repo.PushReference(ctx, desc, bytes.NewReader(blob), args.Tag); err != nil {
+		t.Fatal(err)
+	}
+	labeled := &testImage{repo: img.repo, desc: desc}
+	labeled.digest, err = name.NewDigest(repository+"@"+desc.Digest.String(), name.Insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labeled.sign(t, rhelKey)
+	pullArgs.Destination = t.TempDir()
+	if err := pull(ctx, pullArgs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileDigest(filepath.Join(pullArgs.Destination, "rhel", "9.4", "x86_64", "shim.efi")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,6 +3,7 @@ package nboci
 import (
 	"context"
 	"crypto/sha256"
+	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -11,160 +12,609 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/klauspost/compress/zstd"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
-	"github.com/sigstore/cosign/v2/cmd/cosign/cli/verify"
+	"github.com/sigstore/sigstore/pkg/signature"
 	"oras.land/oras-go/v2/content"
 	"oras.land/oras-go/v2/content/file"
 	"oras.land/oras-go/v2/registry/remote"
-	"oras.land/oras-go/v2/registry/remote/auth"
-	"oras.land/oras-go/v2/registry/remote/credentials"
-	"oras.land/oras-go/v2/registry/remote/retry"
 )
 
 type PullArgs struct {
-	Source       string `arg:"positional,required" help:"repository:tag" placeholder:"REPOSITORY:{TAG|DIGEST}"`
-	Destination  string `arg:"-d,--destination" default:"." help:"destination directory (default: pwd)" placeholder:"DIRECTORY"`
-	SignatureKey string `arg:"-k,--signature-key" help:"signature public key" placeholder:"COSIGN_PUBLIC_FILE"`
+	Source       string   `arg:"positional" help:"repository:tag" placeholder:"REPOSITORY[:TAG|@DIGEST]"`
+	Destination  string   `arg:"-d,--destination" default:"." help:"destination directory (default: pwd)" placeholder:"DIRECTORY"`
+	SignatureKey string   `arg:"-k,--signature-key" help:"signature public key" placeholder:"COSIGN_PUBLIC_FILE"`
+	ForImage     string   `arg:"-f,--for-image" help:"pull artifacts attached to container image" placeholder:"IMAGE"`
+	Arch         []string `arg:"-a,--arch,separate" help:"pull only architecture (default: all)" placeholder:"ARCH"`
+	OS           []string `arg:"-o,--os,separate" help:"pull only OS name (default: all)" placeholder:"NAME"`
+	Version      string   `arg:"-V,--osversion" help:"pull only versions matching glob (9.*) or range (>=9.2,<10)" placeholder:"FILTER"`
+	Latest       int      `arg:"-l,--latest" help:"pull only N latest versions of each OS"`
+	Plain        bool     `arg:"-N,--plain" help:"plain HTTP (insecure)"`
+	Config       string   `arg:"-c,--config" help:"pull all sources described in a sync file" placeholder:"SYNC.yaml"`
+
+	CertificateIdentity         string   `arg:"--certificate-identity" help:"keyless signature identity (e.g. email or URI)" placeholder:"IDENTITY"`
+	CertificateIdentityRegexp   string   `arg:"--certificate-identity-regexp" help:"keyless signature identity regular expression" placeholder:"REGEXP"`
+	CertificateOIDCIssuer       string   `arg:"--certificate-oidc-issuer" help:"keyless signature OIDC issuer" placeholder:"URL"`
+	CertificateOIDCIssuerRegexp string   `arg:"--certificate-oidc-issuer-regexp" help:"keyless signature OIDC issuer regular expression" placeholder:"REGEXP"`
+	CertificateChain            string   `arg:"--certificate-chain" help:"trusted root and intermediate certificates instead of Fulcio roots" placeholder:"PEM_FILE"`
+	IgnoreTlog                  bool     `arg:"--insecure-ignore-tlog" help:"do not verify transparency log inclusion"`
+	IgnoreSCT                   bool     `arg:"--insecure-ignore-sct" help:"do not verify certificate transparency timestamp"`
+	Offline                     bool     `arg:"--offline" help:"verify bundled transparency log entries without network access"`
+	RekorURL                    string   `arg:"--rekor-url" default:"https://rekor.sigstore.dev" help:"Rekor transparency log URL for signatures without bundled entries"`
+	RekorPublicKey              string   `arg:"--rekor-public-key" help:"trusted transparency log public key instead of TUF root" placeholder:"PEM_FILE"`
+	CTLogPublicKey              string   `arg:"--ctlog-public-key" help:"trusted certificate transparency log public key instead of TUF root" placeholder:"PEM_FILE"`
+	NotationTrustPolicy         string   `arg:"--notation-trust-policy" help:"verify notation signatures with trust policy" placeholder:"JSON_FILE"`
+	NotationTrustStore          string   `arg:"--notation-trust-store" help:"notation trust store with x509/TYPE/NAME certificates" placeholder:"DIRECTORY"`
+	VerificationPolicy          string   `arg:"-P,--verification-policy" help:"verify signatures required by policy file rules" placeholder:"POLICY.yaml"`
+	SecureBootCA                []string `arg:"--secureboot-ca,separate" help:"verify entrypoint Authenticode signature against CA certificate (PEM or DER)" placeholder:"CA_FILE"`
+	RequireSecureBoot           bool     `arg:"--require-secureboot" help:"refuse entrypoint which would not boot with Secure Boot"`
+	SBATLevel                   string   `arg:"--sbat-level" help:"check SBAT generations of EFI files against minimum level file" placeholder:"LEVEL_FILE"`
+	RequireSBAT                 bool     `arg:"--require-sbat" help:"refuse EFI files revoked by the SBAT level"`
+	ChecksumsKey                string   `arg:"--checksums-key" help:"sign SHA256SUMS files with cosign private key (password from COSIGN_PASSWORD)" placeholder:"COSIGN_PRIVATE_FILE"`
+	RequireAttestation          []string `arg:"--require-attestation,separate" help:"require signed attestation of predicate type (slsaprovenance, spdxjson, cyclonedx or URI)" placeholder:"TYPE"`
+
+	TagPattern    []string            `arg:"-"`
+	VersionFilter VersionFilter       `arg:"-"`
+	Stats         *PullStats          `arg:"-"`
+	Verifier      Verifier            `arg:"-"`
+	Policy        *VerificationPolicy `arg:"-"`
+	Rule          *PolicyRule         `arg:"-"`
+	Verification  *VerificationResult `arg:"-"`
+	SecureBoot    *x509.CertPool      `arg:"-"`
+	SBAT          SBATLevel           `arg:"-"`
+	Checksums     signature.Signer    `arg:"-"`
+}
+
+// PullStats counts artifacts and files processed by pull.
+type PullStats struct {
+	Artifacts  []string
+	Downloaded int
+	Unchanged  int
 }
 
 func Pull(ctx context.Context, args PullArgs) {
+	if args.RequireSecureBoot && len(args.SecureBootCA) == 0 {
+		Fatal("--require-secureboot requires --secureboot-ca")
+	}
+	if args.RequireSBAT && args.SBATLevel == "" {
+		Fatal("--require-sbat requires --sbat-level")
+	}
+	if args.Config != "" {
+		pullConfig(ctx, args)
+		return
+	}
+	if (args.Source == "") == (args.ForImage == "") {
+		Fatal("either repository or --for-image is required")
+	}
+	if err := args.parseFilters(); err != nil {
+		Fatal(err.Error())
+	}
+	if err := args.checkVerification(); err != nil {
+		Fatal(err.Error())
+	}
+
+	if err := pull(ctx, args); err != nil {
+		Fatal(err.Error())
+	}
+}
+
+// pull pulls netboot artifacts of a repository, tag or digest, or artifacts attached to an image.
+func pull(ctx context.Context, args PullArgs) error {
 	// check if destination is valid
 	if _, err := os.Stat(args.Destination); os.IsNotExist(err) {
 		err = os.MkdirAll(args.Destination, 0700)
 		if err != nil {
-			Fatal("cannot create destination directory")
+			return fmt.Errorf("cannot create destination directory: %w", err)
 		}
 	}
 
 	fs, err := file.New(args.Destination)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer fs.Close()
 
-	var onlyTag string
-	ss := strings.SplitN(args.Source, ":", 2)
-	if len(ss) >= 2 {
-		onlyTag = ss[1]
+	if args.VerificationPolicy != "" && args.Policy == nil {
+		args.Policy, err = loadVerificationPolicy(args.VerificationPolicy, args)
+		if err != nil {
+			return fmt.Errorf("cannot load verification policy: %w", err)
+		}
+	}
+	if args.Verifier == nil {
+		args.Verifier, err = newVerifier(args)
+		if err != nil {
+			return err
+		}
 	}
 
-	repoWithoutTag := ss[0]
-	repo, err := remote.NewRepository(repoWithoutTag)
-	if err != nil {
-		panic(err)
+	if args.SecureBoot == nil {
+		args.SecureBoot, err = loadSecureBootCAs(args.SecureBootCA)
+		if err != nil {
+			return fmt.Errorf("cannot load Secure Boot certificates: %w", err)
+		}
+	}
+	if args.SBAT == nil {
+		args.SBAT, err = loadSBATLevel(args.SBATLevel)
+		if err != nil {
+			return fmt.Errorf("cannot load SBAT level: %w", err)
+		}
+	}
+	if args.Checksums == nil {
+		args.Checksums, err = loadChecksumsSigner(ctx, args.ChecksumsKey)
+		if err != nil {
+			return err
+		}
 	}
 
-	repo.Client = &auth.Client{
-		Client:     retry.DefaultClient,
-		Cache:      auth.NewCache(),
-		Credential: credentials.Credential(NewStore()),
+	if args.ForImage != "" {
+		return pullReferrers(ctx, args)
 	}
 
+	repo := newRepository(args.Source, args.Plain)
+	onlyTag := repo.Reference.Reference
+	if repo.Reference.ValidateReferenceAsDigest() == nil {
+		info, err := fetchArtifactInfo(ctx, repo, onlyTag)
+		if err != nil {
+			return fmt.Errorf("cannot resolve %s: %w", args.Source, err)
+		}
+
+		result, admitted, err := verifySignature(ctx, repo, info, args)
+		if err != nil {
+			return fmt.Errorf("cannot verify %s: %w", args.Source, err)
+		}
+		if !admitted {
+			return nil
+		}
+		args.Verification = result
+		args.Rule = args.Policy.ruleOf(repo, info)
+
+		err = pullDescriptor(ctx, repo, info.desc, args)
+		if err != nil {
+			return fmt.Errorf("cannot pull %s: %w", args.Source, err)
+		}
+		return nil
+	}
+
+	candidates := make([]*artifactInfo, 0)
 	err = repo.Tags(ctx, "", func(tags []string) error {
 		for _, tag := range tags {
-			if (onlyTag != "" && tag != onlyTag) || (strings.HasSuffix(tag, ".sig")) {
+			if (onlyTag != "" && tag != onlyTag) || isHelperTag(tag) || !matchTag(tag, args.TagPattern) {
 				continue
 			}
 
-			desc, err := repo.Resolve(ctx, tag)
+			info, err := fetchArtifactInfo(ctx, repo, tag)
 			if err != nil {
 				return err
 			}
+			if args.selects(info) {
+				candidates = append(candidates, info)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot pull %s: %w", args.Source, err)
+	}
+	if args.Latest > 0 {
+		candidates = selectLatest(candidates, args.Latest)
+	}
+
+	for _, info := range candidates {
+		result, admitted, err := verifySignature(ctx, repo, info, args)
+		if err != nil {
+			return fmt.Errorf("cannot verify %s: %w", reference(repo, info.tag), err)
+		}
+		if !admitted {
+			continue
+		}
+		args.Verification = result
+		args.Rule = args.Policy.ruleOf(repo, info)
+
+		if err := pullDescriptor(ctx, repo, info.desc, args); err != nil {
+			return fmt.Errorf("cannot pull %s: %w", reference(repo, info.tag), err)
+		}
+	}
 
-			if args.SignatureKey != "" {
-				ref := fmt.Sprintf("%s:%s", repoWithoutTag, tag)
-				Debug("checking signature of", ref)
-				// verify using cosign - this will print some messages to stdout/stderr
-				verifyCmd := verify.VerifyCommand{
-					KeyRef: args.SignatureKey,
-					Output: "text",
+	return nil
+}
+
+// parseFilters validates filters of pull arguments.
+func (args *PullArgs) parseFilters() error {
+	var err error
+	args.VersionFilter, err = ParseVersionFilter(args.Version)
+	if err != nil {
+		return err
+	}
+	if args.Latest < 0 {
+		return errors.New("latest must be a positive number")
+	}
+	for _, a := range args.Arch {
+		if !ArchRegexp.MatchString(a) {
+			return fmt.Errorf("unknown architecture %s", a)
+		}
+	}
+
+	return nil
+}
+
+// selects returns true when a tagged manifest or index is a netboot artifact matching OS,
+// version and architecture filters. Only annotations are used, no blobs are fetched.
+func (args PullArgs) selects(info *artifactInfo) bool {
+	if !info.isNetboot() {
+		Debug("skipping", info.tag, "which is not a netboot artifact")
+		return false
+	}
+
+	a := info.annotations
+	if len(args.OS) > 0 && !slices.Contains(args.OS, a[AnnotationOSName]) {
+		Debug("skipping", info.tag, "of OS", a[AnnotationOSName])
+		return false
+	}
+	if !args.VersionFilter.Match(a[AnnotationOSVersion]) {
+		Debug("skipping", info.tag, "of version", a[AnnotationOSVersion])
+		return false
+	}
+	// indexes have no architecture, their entries are filtered on pull
+	if arch := a[AnnotationOSArch]; arch != "" && len(args.Arch) > 0 && !slices.Contains(args.Arch, arch) {
+		Debug("skipping", info.tag, "of architecture", arch)
+		return false
+	}
+
+	return true
+}
+
+// selectLatest returns artifacts of the n latest versions of each OS.
+func selectLatest(infos []*artifactInfo, n int) []*artifactInfo {
+	versions := make(map[string][]string)
+	for _, info := range infos {
+		name, version := info.annotations[AnnotationOSName], info.annotations[AnnotationOSVersion]
+		if !slices.Contains(versions[name], version) {
+			versions[name] = append(versions[name], version)
+		}
+	}
+	for name := range versions {
+		slices.SortFunc(versions[name], func(a, b string) int {
+			return CompareVersions(b, a)
+		})
+		if len(versions[name]) > n {
+			versions[name] = versions[name][:n]
+		}
+	}
+
+	return slices.DeleteFunc(infos, func(info *artifactInfo) bool {
+		return !slices.Contains(versions[info.annotations[AnnotationOSName]], info.annotations[AnnotationOSVersion])
+	})
+}
+
+// matchTag returns true when there are no patterns or the tag matches one of shell patterns.
+func matchTag(tag string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, tag); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pullReferrers pulls netboot artifacts attached to a container image via the referrers API,
+// or the referrers tag schema for registries without it.
+func pullReferrers(ctx context.Context, args PullArgs) error {
+	repo := newRepository(args.ForImage, args.Plain)
+	ref := repo.Reference.Reference
+	if ref == "" {
+		ref = "latest"
+	}
+
+	desc, err := repo.Resolve(ctx, ref)
+	if err != nil {
+		return fmt.Errorf("cannot resolve image %s: %w", args.ForImage, err)
+	}
+
+	// artifacts are attached to the platform manifest of multi-architecture images
+	subjects := []ocispec.Descriptor{desc}
+	if desc.MediaType == ocispec.MediaTypeImageIndex || desc.MediaType == dockerManifestListMediaType {
+		blob, err := content.FetchAll(ctx, repo, desc)
+		if err != nil {
+			return fmt.Errorf("cannot fetch image index: %w", err)
+		}
+		var index ocispec.Index
+		if err := json.Unmarshal(blob, &index); err != nil {
+			return fmt.Errorf("cannot parse image index: %w", err)
+		}
+		subjects = append(subjects, index.Manifests...)
+	}
+
+	found := 0
+	for _, subject := range subjects {
+		Debug("looking for referrers of", subject.Digest.String())
+		err = repo.Referrers(ctx, subject, "", func(referrers []ocispec.Descriptor) error {
+			for _, r := range referrers {
+				// some registries report config media type instead of the artifact type
+				if r.ArtifactType != NetbootArtifactType && r.ArtifactType != NetbootConfigMediaType && r.ArtifactType != UnknownArtifactType && r.ArtifactType != EmptyType {
+					continue
 				}
-				err = verifyCmd.Exec(ctx, []string{ref})
+				ok, err := isNetbootReferrer(ctx, repo, r)
 				if err != nil {
 					return err
 				}
-			}
-
-			if desc.MediaType == ocispec.MediaTypeImageManifest {
-				Debug("processing", tag)
-
-				blob, err := content.FetchAll(ctx, repo, desc)
+				if !ok {
+					Debug("not a netboot artifact", r.Digest.String())
+					continue
+				}
+				found++
+				info := &artifactInfo{tag: r.Digest.String(), desc: r, annotations: r.Annotations}
+				result, admitted, err := verifySignature(ctx, repo, info, args)
 				if err != nil {
 					return err
 				}
-				var manifest ocispec.Manifest
-				if err := json.Unmarshal(blob, &manifest); err != nil {
+				if !admitted {
+					continue
+				}
+				args.Verification = result
+				args.Rule = args.Policy.ruleOf(repo, info)
+				if err := pullDescriptor(ctx, repo, r, args); err != nil {
 					return err
 				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("cannot pull referrers of %s: %w", subject.Digest, err)
+		}
+	}
 
-				destPath, err := makePath(manifest.Annotations)
-				if err != nil {
-					continue
-				}
-				dirname := path.Join(args.Destination, destPath)
+	if found == 0 {
+		return fmt.Errorf("no netboot artifacts attached to %s", args.ForImage)
+	}
 
-				ss, err := content.Successors(ctx, repo, desc)
-				if err != nil {
-					FatalErr(err, "cannot list successors")
-				}
+	return nil
+}
+
+// isNetbootReferrer returns true for netboot indexes and for manifests with netboot config or
+// file layers. Other artifacts with unknown or empty artifact type (e.g. SBOMs) are not netboot.
+func isNetbootReferrer(ctx context.Context, repo *remote.Repository, desc ocispec.Descriptor) (bool, error) {
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex:
+		return desc.ArtifactType == NetbootArtifactType, nil
+	case ocispec.MediaTypeImageManifest:
+	default:
+		return false, nil
+	}
+
+	blob, err := content.FetchAll(ctx, repo, desc)
+	if err != nil {
+		return false, fmt.Errorf("cannot fetch referrer %s: %w", desc.Digest, err)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(blob, &manifest); err != nil {
+		return false, fmt.Errorf("cannot parse referrer %s: %w", desc.Digest, err)
+	}
+	if manifest.Config.MediaType == NetbootConfigMediaType {
+		return true, nil
+	}
+	for _, l := range manifest.Layers {
+		if l.MediaType == NetbootFileZstdMediaType {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// reference returns full reference of a tag or digest in the repository.
+func reference(repo *remote.Repository, tagOrDigest string) string {
+	if strings.HasPrefix(tagOrDigest, "sha256:") {
+		return fmt.Sprintf("%s/%s@%s", repo.Reference.Registry, repo.Reference.Repository, tagOrDigest)
+	}
+	return fmt.Sprintf("%s/%s:%s", repo.Reference.Registry, repo.Reference.Repository, tagOrDigest)
+}
+
+// pullDescriptor downloads files of a netboot manifest into destination directory.
+func pullDescriptor(ctx context.Context, repo *remote.Repository, desc ocispec.Descriptor, args PullArgs) error {
+	if desc.MediaType == ocispec.MediaTypeImageIndex {
+		return pullIndex(ctx, repo, desc, args)
+	}
+	if desc.MediaType != ocispec.MediaTypeImageManifest {
+		return nil
+	}
+
+	Debug("processing", desc.Digest.String())
+	blob, err := content.FetchAll(ctx, repo, desc)
+	if err != nil {
+		return err
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(blob, &manifest); err != nil {
+		return err
+	}
+
+	if !IsNetbootArtifact(manifest.ArtifactType, manifest.Annotations) {
+		Debug("not a netboot artifact", desc.Digest.String())
+		return nil
+	}
+	if IsLegacyArtifact(manifest.ArtifactType, manifest.Annotations) {
+		Debug("legacy netboot artifact", desc.Digest.String())
+	}
+
+	config, err := loadNetbootConfig(ctx, repo, &manifest)
+	if err != nil {
+		return err
+	}
+	destPath, err := config.Path()
+	if err != nil {
+		return err
+	}
+	if len(args.Arch) > 0 && !slices.Contains(args.Arch, config.OS.Architecture) {
+		Debug("skipping architecture", config.OS.Architecture)
+		return nil
+	}
+	dirname := path.Join(args.Destination, destPath)
+
+	if len(args.OS) > 0 && !slices.Contains(args.OS, config.OS.Name) {
+		Debug("skipping OS", config.OS.Name)
+		return nil
+	}
+	if !args.VersionFilter.Match(config.OS.Version) {
+		Debug("skipping version", config.OS.Version)
+		return nil
+	}
+
+	// the rule was selected by annotations of the tag, index or referrer before verification
+	if err := args.Policy.checkRule(repo, args.Rule, config.OS.Name, manifest.Annotations[AnnotationOSName]); err != nil {
+		return err
+	}
+	attested, admitted, err := verifyAttestations(ctx, repo, desc, config.OS.Name, args)
+	if err != nil {
+		return err
+	}
+	if !admitted {
+		return nil
+	}
+	if len(attested) > 0 && args.Verification != nil {
+		result := *args.Verification
+		result.Attestations = attested
+		args.Verification = &result
+	}
 
-				for _, s := range ss {
-					if s.MediaType != NetbootFileZstdMediaType {
-						continue
-					}
-
-					name, ok := s.Annotations["org.opencontainers.image.title"]
-					if !ok {
-						Fatal("artifact is missing org.opencontainers.image.title annotation for", s.Digest.String())
-					}
-
-					err := os.MkdirAll(dirname, 0777)
-					if err != nil {
-						FatalErr(err, "cannot create destination directory")
-					}
-					filename := path.Join(dirname, name)
-
-					fdigest, _ := fileDigest(filename)
-					rdigest, ok := s.Annotations["org.pulpproject.netboot.src.digest"]
-					if ok && rdigest == fdigest {
-						Debug("digest match for", filename)
-						continue
-					}
-
-					// download
-					Print("downloading", filename)
-					hash, err := download(ctx, repo, s, filename)
-					if err != nil {
-						FatalErr(err, "cannot download", filename)
-					}
-
-					if rdigest != "" && rdigest != hash {
-						Fatal("downloaded file", filename, "has different digest", hash, "than expected", rdigest)
-					}
+	ss, err := content.Successors(ctx, repo, desc)
+	if err != nil {
+		return fmt.Errorf("cannot list successors: %w", err)
+	}
+	if args.Stats != nil && !slices.Contains(args.Stats.Artifacts, destPath) {
+		args.Stats.Artifacts = append(args.Stats.Artifacts, destPath)
+	}
+
+	digests := make(map[string]string, len(ss))
+	for _, s := range ss {
+		if s.MediaType != NetbootFileZstdMediaType {
+			continue
+		}
+
+		name, ok := s.Annotations[AnnotationTitle]
+		if !ok {
+			return fmt.Errorf("artifact is missing org.opencontainers.image.title annotation for %s", s.Digest)
+		}
+		if !validPathElement(name) {
+			return fmt.Errorf("invalid file name %q of %s", name, s.Digest)
+		}
+
+		err := os.MkdirAll(dirname, 0777)
+		if err != nil {
+			return fmt.Errorf("cannot create destination directory: %w", err)
+		}
+		filename := path.Join(dirname, name)
+
+		// boot files are only installed when they pass Secure Boot verification and SBAT level,
+		// checks of files which are not PE images only read their header
+		entrypoint := name == filepath.Base(config.EntryPoints.Default)
+		validate := (args.SecureBoot != nil && entrypoint) || args.SBAT != nil
+		// EFI images are downloaded next to the installed file which is replaced when they are valid
+		staged := validate && (entrypoint || s.Annotations[AnnotationSrcSBAT] != "" || strings.EqualFold(filepath.Ext(name), ".efi"))
+
+		fdigest, _ := fileDigest(filename)
+		rdigest, ok := s.Annotations[AnnotationSrcDigest]
+		if ok && rdigest == fdigest {
+			Debug("digest match for", filename)
+			if validate {
+				if err := args.checkBootFile(filename, name, entrypoint); err != nil {
+					return err
 				}
+			}
+			if args.Stats != nil {
+				args.Stats.Unchanged++
+			}
+			digests[name] = fdigest
+			continue
+		}
 
-				ep := manifest.Annotations["org.pulpproject.netboot.entrypoint"]
-				ensureEntrypoint(path.Join(dirname, "boot"), path.Join(dirname, filepath.Base(ep)))
-				aep := manifest.Annotations["org.pulpproject.netboot.altentrypoint"]
-				ensureEntrypoint(path.Join(dirname, "boot-alt"), path.Join(dirname, filepath.Base(aep)))
-				lep := manifest.Annotations["org.pulpproject.netboot.legacyentrypoint"]
-				ensureEntrypoint(path.Join(dirname, "boot-legacy"), path.Join(dirname, filepath.Base(lep)))
+		// download
+		Print("downloading", filename)
+		dest := filename
+		if staged {
+			dest = filename + ".part"
+		}
+		hash, err := download(ctx, repo, s, dest)
+		if err != nil {
+			return fmt.Errorf("cannot download %s: %w", filename, err)
+		}
+
+		if rdigest != "" && rdigest != hash {
+			return fmt.Errorf("downloaded file %s has different digest %s than expected %s", filename, hash, rdigest)
+		}
+		if validate {
+			if err := args.checkBootFile(dest, name, entrypoint); err != nil {
+				os.Remove(dest)
+				return err
+			}
+		}
+		if staged {
+			if err := os.Rename(dest, filename); err != nil {
+				return err
 			}
 		}
+		if args.Stats != nil {
+			args.Stats.Downloaded++
+		}
+		digests[name] = hash
+	}
+
+	if err := writeChecksums(dirname, digests, args.Checksums); err != nil {
+		return fmt.Errorf("cannot write checksums: %w", err)
+	}
+	if err := writeVerification(dirname, args.Verification); err != nil {
+		return fmt.Errorf("cannot record verification: %w", err)
+	}
+
+	ensureEntrypoint(dirname, "boot", config.EntryPoints.Default)
+	ensureEntrypoint(dirname, "boot-alt", config.EntryPoints.Alternate)
+	ensureEntrypoint(dirname, "boot-legacy", config.EntryPoints.Legacy)
+
+	if config.Kernel != nil {
+		Print("kernel", config.Kernel.File, "initrd", config.Kernel.Initrd, "args", config.Kernel.Args)
+		err = writeGrubConfig(path.Join(dirname, "grub.cfg"), config)
+		if err != nil {
+			ErrorErr(err, "cannot write grub configuration")
+		}
+	}
+
+	return nil
+}
+
+// checkBootFile validates SBAT generations of a pulled file and Secure Boot signature of the
+// entrypoint, name is the installed file name.
+func (args PullArgs) checkBootFile(filename, name string, entrypoint bool) error {
+	if entrypoint {
+		if _, err := checkSecureBoot(filename, name, args.SecureBoot, args.RequireSecureBoot); err != nil {
+			return err
+		}
+	}
+
+	if args.SBAT == nil {
 		return nil
-	})
+	}
+	sbat, err := readFileSBAT(filename, name, args.RequireSBAT)
 	if err != nil {
-		FatalErr(err, "cannot list tags")
+		return err
 	}
+
+	return checkSBAT(sbat, name, args.SBAT, args.RequireSBAT)
 }
 
-func ensureEntrypoint(link, dest string) {
-	if link == "" || dest == "" {
+// ensureEntrypoint creates or updates symlink named link in dirname pointing to the entrypoint,
+// nothing is done for empty entrypoint.
+func ensureEntrypoint(dirname, link, entrypoint string) {
+	if entrypoint == "" {
 		return
 	}
+	link = path.Join(dirname, link)
+	dest := path.Join(dirname, filepath.Base(entrypoint))
 
 	if _, err := os.Stat(dest); errors.Is(err, os.ErrNotExist) {
 		ErrorErr(err, "entrypoint destination not exist")
@@ -184,7 +634,8 @@ func ensureEntrypoint(link, dest string) {
 		// is different symlink
 		err = os.Remove(link)
 		if err != nil {
-			FatalErr(err, "cannot remove existing file", link)
+			ErrorErr(err, "cannot remove existing file", link)
+			return
 		}
 		makeSymlink(link, dest)
 	}
@@ -198,22 +649,6 @@ func makeSymlink(link, dest string) {
 	}
 }
 
-func makePath(a map[string]string) (string, error) {
-	keys := []string{
-		"org.pulpproject.netboot.os.name",
-		"org.pulpproject.netboot.os.version",
-		"org.pulpproject.netboot.os.arch",
-	}
-
-	for _, key := range keys {
-		if _, ok := a[key]; !ok {
-			return "", fmt.Errorf("missing %s annotation", key)
-		}
-	}
-
-	return path.Join(a[keys[0]], a[keys[1]], a[keys[2]]), nil
-}
-
 func fileDigest(filename string) (string, error) {
 	file, err := os.Open(filename)
 	if err != nil {
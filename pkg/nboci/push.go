@@ -6,11 +6,14 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/klauspost/compress/zstd"
 	"github.com/opencontainers/go-digest"
@@ -18,106 +21,361 @@ import (
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"oras.land/oras-go/v2/content"
 	"oras.land/oras-go/v2/registry/remote"
-	"oras.land/oras-go/v2/registry/remote/auth"
-	"oras.land/oras-go/v2/registry/remote/credentials"
-	"oras.land/oras-go/v2/registry/remote/retry"
 )
 
 type PushArgs struct {
-	File             []string `arg:"positional,required" help:"boot file"`
-	Plain            bool     `arg:"-N,--plain" help:"plain HTTP (insecure)"`
-	Repository       string   `arg:"-r,--repository,required" help:"repository (e.g. ghcr.io/user/repo)"`
-	Name             string   `arg:"-n,--osname,required" help:"distribution name (e.g. fedora, debian)"`
-	Version          string   `arg:"-v,--osversion,required" help:"distribution version (e.g. 45, 9.6)"`
-	Architecture     string   `arg:"-a,--osarch,required" help:"architecture (e.g. x86_64, arm64)"`
-	Tag              string   `arg:"-t,--tag" help:"tag (default: name-version-arch)"`
-	EntryPoint       string   `arg:"-e,--entrypoint,required" help:"entry point (default: shim.efi)"`
-	AltEntryPoint    string   `arg:"-E,--alt-entrypoint" help:"alternative entry point"`
-	LegacyEntryPoint string   `arg:"-G,--legacy-entrypoint" help:"legacy entry point"`
+	File              []string            `arg:"positional" help:"boot file"`
+	Plain             bool                `arg:"-N,--plain" help:"plain HTTP (insecure)"`
+	Repository        string              `arg:"-r,--repository" help:"repository (e.g. ghcr.io/user/repo)"`
+	Name              string              `arg:"-n,--osname" help:"distribution name (e.g. fedora, debian)"`
+	Version           string              `arg:"-v,--osversion" help:"distribution version (e.g. 45, 9.6)"`
+	Architecture      string              `arg:"-a,--osarch" help:"architecture (e.g. x86_64, arm64)"`
+	Tag               string              `arg:"-t,--tag" help:"tag (default: name-version-arch)"`
+	EntryPoint        string              `arg:"-e,--entrypoint" help:"entry point (e.g. shim.efi)"`
+	AltEntryPoint     string              `arg:"-E,--alt-entrypoint" help:"alternative entry point"`
+	LegacyEntryPoint  string              `arg:"-G,--legacy-entrypoint" help:"legacy entry point"`
+	Kernel            string              `arg:"-K,--kernel" help:"kernel file (e.g. vmlinuz)"`
+	Initrd            string              `arg:"-I,--initrd" help:"initial ramdisk file (e.g. initrd.img)"`
+	KernelArgs        string              `arg:"-A,--kernel-args" help:"kernel command line arguments"`
+	Detect            bool                `arg:"-D,--detect" help:"detect name, version and architecture from kernel and initrd"`
+	FromISO           string              `arg:"--from-iso" help:"extract boot files from installation ISO" placeholder:"ISO"`
+	ISOFile           []string            `arg:"--iso-file,separate" help:"boot file path in ISO overriding well-known path (shim, grub, kernel or initrd)" placeholder:"ROLE=PATH"`
+	FromImage         string              `arg:"--from-image" help:"extract boot files from bootc container image" placeholder:"IMAGE"`
+	Subject           string              `arg:"--subject" help:"attach artifact to container image in the same repository" placeholder:"TAG|DIGEST"`
+	Attach            bool                `arg:"--attach" help:"attach artifact to the image from --from-image"`
+	Index             bool                `arg:"--index" help:"create or update multi-architecture index"`
+	IndexTag          string              `arg:"--index-tag" help:"index tag (default: name-version)"`
+	NetbootConfig     bool                `arg:"--netboot-config" help:"push structured netboot config blob instead of empty config"`
+	Annotation        map[string]string   `arg:"--annotation,separate" help:"additional manifest annotation" placeholder:"KEY=VALUE"`
+	Config            string              `arg:"-c,--config" help:"push all artifacts described in a release file" placeholder:"RELEASE.yaml"`
+	SignKey           string              `arg:"-k,--sign-key" help:"sign with cosign private key (password from COSIGN_PASSWORD)" placeholder:"COSIGN_PRIVATE_FILE"`
+	IdentityToken     string              `arg:"--identity-token" help:"sign keyless with OIDC identity token or token file" placeholder:"TOKEN"`
+	FulcioURL         string              `arg:"--fulcio-url" default:"https://fulcio.sigstore.dev" help:"Fulcio URL for keyless signing"`
+	RekorURL          string              `arg:"--rekor-url" default:"https://rekor.sigstore.dev" help:"Rekor transparency log URL"`
+	NoTlog            bool                `arg:"--no-tlog" help:"do not upload signature to transparency log"`
+	Yes               bool                `arg:"-y,--yes" help:"skip confirmation of transparency log upload"`
+	SecureBootCA      []string            `arg:"--secureboot-ca,separate" help:"verify entrypoint Authenticode signature against CA certificate (PEM or DER)" placeholder:"CA_FILE"`
+	RequireSecureBoot bool                `arg:"--require-secureboot" help:"refuse entrypoint which would not boot with Secure Boot"`
+	SBATLevel         string              `arg:"--sbat-level" help:"check SBAT generations of EFI files against minimum level file" placeholder:"LEVEL_FILE"`
+	RequireSBAT       bool                `arg:"--require-sbat" help:"refuse EFI files revoked by the SBAT level"`
+	Attestation       map[string]string   `arg:"--attestation,separate" help:"attach signed in-toto attestation of predicate type (slsaprovenance, spdxjson, cyclonedx or URI)" placeholder:"TYPE=FILE"`
+	ExtraTags         []string            `arg:"-"`
+	KernelVersion     string              `arg:"-"`
+	FileRPM           map[string]string   `arg:"-"`
+	FileSigner        map[string]string   `arg:"-"`
+	FileSBAT          map[string]string   `arg:"-"`
+	SubjectDesc       *ocispec.Descriptor `arg:"-"`
 }
 
 func Push(ctx context.Context, args PushArgs) {
+	if args.Config != "" {
+		pushRelease(ctx, args)
+		return
+	}
+	if args.Repository == "" {
+		Fatal("--repository or --config is required")
+	}
+
+	dir := mkTempDir()
+	defer os.RemoveAll(dir)
+
+	imageDesc, err := preparePush(ctx, &args, dir)
+	if err != nil {
+		Fatal(err.Error())
+	}
+
+	if err := pushArtifact(ctx, args, imageDesc); err != nil {
+		Fatal(err.Error())
+	}
+}
+
+// preparePush extracts files from ISO, container image and RPM packages into dir, detects
+// metadata and validates arguments. Nothing is uploaded. Returns descriptor of the container
+// image manifest when extracting from an image.
+func preparePush(ctx context.Context, args *PushArgs, dir string) (ocispec.Descriptor, error) {
+	var imageDesc ocispec.Descriptor
+	if args.FromISO != "" {
+		if err := extractISO(args, dir); err != nil {
+			return imageDesc, err
+		}
+	}
+	if args.FromImage != "" {
+		var err error
+		imageDesc, err = extractImage(ctx, args, dir)
+		if err != nil {
+			return imageDesc, err
+		}
+	}
+	if args.Attach && args.FromImage == "" {
+		return imageDesc, errors.New("--attach requires --from-image")
+	}
+	if args.Attach && args.Subject != "" {
+		return imageDesc, errors.New("--attach and --subject are mutually exclusive")
+	}
+	if err := extractRPMs(args, dir); err != nil {
+		return imageDesc, err
+	}
+
+	if len(args.File) == 0 {
+		return imageDesc, errors.New("no files to push")
+	}
+	if args.EntryPoint == "" {
+		return imageDesc, errors.New("entry point is required")
+	}
+
+	if args.Detect {
+		if err := detectMetadata(args); err != nil {
+			return imageDesc, err
+		}
+	}
+
+	if args.Name == "" || args.Version == "" || args.Architecture == "" {
+		return imageDesc, errors.New("name, version and architecture are required, use --detect to detect them from files")
+	}
+
 	slog.Debug("checking arguments", "name", args.Name, "version", args.Version, "arch", args.Architecture)
 	if !AlphanumRegexp.MatchString(args.Name) {
-		Fatal("invalid character in name")
+		return imageDesc, errors.New("invalid character in name")
 	}
 	if !AlphanumRegexp.MatchString(args.Version) {
-		Fatal("invalid character in version")
+		return imageDesc, errors.New("invalid character in version")
 	}
 	if !AlphanumRegexp.MatchString(args.Architecture) {
-		Fatal("invalid character in architecture")
+		return imageDesc, errors.New("invalid character in architecture")
 	}
 	if !ArchRegexp.MatchString(args.Architecture) {
-		Fatal("unknown architecture")
+		return imageDesc, errors.New("unknown architecture")
 	}
-	if !AlphanumRegexp.MatchString(args.Name) {
-		Fatal("invalid character in name")
+	if args.Kernel == "" && (args.Initrd != "" || args.KernelArgs != "") {
+		return imageDesc, errors.New("initrd or kernel arguments require kernel")
+	}
+	if strings.ContainsAny(args.KernelArgs, "\n\r") {
+		return imageDesc, errors.New("kernel arguments must be a single line")
+	}
+	for _, f := range []string{args.EntryPoint, args.AltEntryPoint, args.LegacyEntryPoint, args.Kernel, args.Initrd} {
+		if f != "" && !slices.Contains(fileNames(args.File), f) {
+			return imageDesc, fmt.Errorf("file %s is not being pushed", f)
+		}
+	}
+	for _, f := range args.File {
+		if _, err := os.Stat(f); err != nil {
+			return imageDesc, err
+		}
+	}
+	names := fileNames(args.File)
+	for i, n := range names {
+		if slices.Contains(names[:i], n) {
+			return imageDesc, fmt.Errorf("file name %s is pushed more than once", n)
+		}
+	}
+	for k := range args.Annotation {
+		if strings.HasPrefix(k, "org.pulpproject.netboot.") {
+			return imageDesc, fmt.Errorf("annotation %s is reserved", k)
+		}
 	}
 
 	// generate tag
 	if args.Tag == "" {
 		args.Tag = fmt.Sprintf("%s-%s-%s", args.Name, args.Version, args.Architecture)
 	}
-
-	repo, err := remote.NewRepository(args.Repository)
+	if args.SignKey != "" && args.IdentityToken != "" {
+		return imageDesc, errors.New("--sign-key and --identity-token are mutually exclusive")
+	}
+	if args.SignKey != "" {
+		if _, err := os.Stat(args.SignKey); err != nil {
+			return imageDesc, err
+		}
+	}
+	if err := args.checkAttestations(); err != nil {
+		return imageDesc, err
+	}
+	if args.RequireSecureBoot && len(args.SecureBootCA) == 0 {
+		return imageDesc, errors.New("--require-secureboot requires --secureboot-ca")
+	}
+	roots, err := loadSecureBootCAs(args.SecureBootCA)
 	if err != nil {
-		FatalErr(err, "cannot create repository")
+		return imageDesc, err
 	}
-
-	repo.Client = &auth.Client{
-		Client:     retry.DefaultClient,
-		Cache:      auth.DefaultCache,
-		Credential: credentials.Credential(NewStore()),
+	// only signers which chain to the Secure Boot certificates are recorded
+	args.FileSigner = make(map[string]string)
+	for _, f := range args.File {
+		name := filepath.Base(f)
+		if name == args.EntryPoint {
+			signer, err := checkSecureBoot(f, name, roots, args.RequireSecureBoot)
+			if err != nil {
+				return imageDesc, err
+			}
+			if signer != "" {
+				args.FileSigner[name] = signer
+			}
+			continue
+		}
+		if roots == nil {
+			continue
+		}
+		signer, err := verifySecureBoot(f, roots)
+		if err != nil {
+			Debug("not recording signer of", name, err.Error())
+			continue
+		}
+		args.FileSigner[name] = signer
 	}
-	if args.Plain {
-		repo.PlainHTTP = true
+	if args.RequireSBAT && args.SBATLevel == "" {
+		return imageDesc, errors.New("--require-sbat requires --sbat-level")
+	}
+	level, err := loadSBATLevel(args.SBATLevel)
+	if err != nil {
+		return imageDesc, fmt.Errorf("cannot load SBAT level: %w", err)
+	}
+	// SBAT data is recorded as file annotation
+	args.FileSBAT = make(map[string]string)
+	for _, f := range args.File {
+		name := filepath.Base(f)
+		sbat, err := readFileSBAT(f, name, args.RequireSBAT)
+		if err != nil {
+			return imageDesc, err
+		}
+		if len(sbat) > 0 {
+			args.FileSBAT[name] = string(sbat)
+		}
+		if err := checkSBAT(sbat, name, level, args.RequireSBAT); err != nil {
+			return imageDesc, err
+		}
 	}
 
-	dir := mkTempDir()
-	defer os.RemoveAll(dir)
+	if args.Index && args.IndexTag == "" {
+		args.IndexTag = fmt.Sprintf("%s-%s", args.Name, args.Version)
+	}
+
+	return imageDesc, nil
+}
+
+// pushArtifact uploads files, config and manifest of prepared push arguments.
+func pushArtifact(ctx context.Context, args PushArgs, imageDesc ocispec.Descriptor) error {
+	repo := newRepository(args.Repository, args.Plain)
+
+	if args.Attach {
+		image := newRepository(args.FromImage, args.Plain)
+		if image.Reference.Registry != repo.Reference.Registry || image.Reference.Repository != repo.Reference.Repository {
+			return fmt.Errorf("artifacts can only be attached to images in the same repository %s", args.Repository)
+		}
+		args.SubjectDesc = &imageDesc
+	} else if args.Subject != "" {
+		desc, err := repo.Resolve(ctx, args.Subject)
+		if err != nil {
+			return fmt.Errorf("cannot resolve subject %s: %w", args.Subject, err)
+		}
+		args.SubjectDesc = &desc
+	}
+	if args.SubjectDesc != nil {
+		Debug("attaching to", args.SubjectDesc.Digest.String())
+	}
 
 	descs := make([]ocispec.Descriptor, 0, len(args.File))
 	for _, f := range args.File {
 		Debug("compressing", f)
 		a, err := newArtifact(f)
 		if err != nil {
-			FatalErr(err, "cannot load file")
+			return fmt.Errorf("cannot load file: %w", err)
 		}
 		d := *a.Descriptor()
+		if nevra, ok := args.FileRPM[filepath.Base(f)]; ok {
+			d.Annotations[AnnotationSrcRPM] = nevra
+		}
+		if signer, ok := args.FileSigner[filepath.Base(f)]; ok {
+			d.Annotations[AnnotationSrcSigner] = signer
+		}
+		if sbat, ok := args.FileSBAT[filepath.Base(f)]; ok {
+			d.Annotations[AnnotationSrcSBAT] = sbat
+		}
 		descs = append(descs, d)
 
 		Debug("pushing", f)
-		err = repo.Push(ctx, d, a.Reader())
+		if err := repo.Push(ctx, d, a.Reader()); err != nil {
+			return fmt.Errorf("cannot push layer: %w", err)
+		}
+	}
+
+	config, configBlob := ocispec.DescriptorEmptyJSON, ocispec.DescriptorEmptyJSON.Data
+	if args.NetbootConfig {
+		var err error
+		configBlob, err = json.Marshal(newNetbootConfig(args, descs))
 		if err != nil {
-			FatalErr(err, "cannot push layer")
+			return fmt.Errorf("cannot generate config: %w", err)
 		}
+		config = content.NewDescriptorFromBytes(NetbootConfigMediaType, configBlob)
 	}
 
-	manifest, err := generateManifest(ocispec.DescriptorEmptyJSON,
-		args.Name,
-		args.Version,
-		args.Architecture,
-		args.EntryPoint,
-		args.AltEntryPoint,
-		args.LegacyEntryPoint,
-		descs...)
+	manifest, err := generateManifest(config, args, descs...)
 	if err != nil {
-		FatalErr(err, "cannot generate manifest")
+		return fmt.Errorf("cannot generate manifest: %w", err)
 	}
 
 	desc := content.NewDescriptorFromBytes(ocispec.MediaTypeImageManifest, manifest)
 
 	Print("pushing config")
-	err = repo.Push(ctx, ocispec.DescriptorEmptyJSON, bytes.NewReader(ocispec.DescriptorEmptyJSON.Data))
+	err = repo.Push(ctx, config, bytes.NewReader(configBlob))
 	if err != nil {
-		FatalErr(err, "cannot push config")
+		return fmt.Errorf("cannot push config: %w", err)
+	}
+
+	if len(args.Attestation) > 0 {
+		// attestations are attached before the manifest is tagged
+		err = repo.Push(ctx, desc, bytes.NewReader(manifest))
+		if err != nil {
+			return fmt.Errorf("cannot push manifest: %w", err)
+		}
+		err = pushAttestations(ctx, repo, desc, args)
+		if err != nil {
+			return fmt.Errorf("cannot attach attestations: %w", err)
+		}
 	}
 
 	Print("pushing manifest")
-	err = repo.PushReference(ctx, desc, bytes.NewReader(manifest), args.Tag)
+	err = publish(ctx, repo, desc, manifest, append([]string{args.Tag}, args.ExtraTags...), args)
 	if err != nil {
-		FatalErr(err, "cannot push manifest")
+		return fmt.Errorf("cannot push manifest: %w", err)
 	}
+
+	if args.Index {
+		Print("updating index", args.IndexTag)
+		err = updateIndex(ctx, repo, args.IndexTag, desc, args)
+		if err != nil {
+			return fmt.Errorf("cannot update index: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// publish pushes manifest or index by digest, signs it when requested and only then tags it, so
+// tags never point to unsigned content.
+func publish(ctx context.Context, repo *remote.Repository, desc ocispec.Descriptor, blob []byte, tags []string, args PushArgs) error {
+	if !args.isSigning() {
+		for _, tag := range tags {
+			Debug("tagging", tag)
+			if err := repo.PushReference(ctx, desc, bytes.NewReader(blob), tag); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := repo.Push(ctx, desc, bytes.NewReader(blob)); err != nil {
+		return err
+	}
+	Print("signing", desc.Digest.String())
+	if err := signDigest(reference(repo, desc.Digest.String()), args); err != nil {
+		return fmt.Errorf("cannot sign: %w", err)
+	}
+	for _, tag := range tags {
+		Debug("tagging", tag)
+		if err := repo.PushReference(ctx, desc, bytes.NewReader(blob), tag); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 type Artifact struct {
@@ -135,9 +393,9 @@ func (a *Artifact) Descriptor() *ocispec.Descriptor {
 		Digest:    digest.Digest(a.digest),
 		Size:      a.size,
 		Annotations: map[string]string{
-			"org.opencontainers.image.title":     a.filename,
-			"org.pulpproject.netboot.src.size":   fmt.Sprintf("%d", a.srcSize),
-			"org.pulpproject.netboot.src.digest": a.srcDigest,
+			AnnotationTitle:     a.filename,
+			AnnotationSrcSize:   fmt.Sprintf("%d", a.srcSize),
+			AnnotationSrcDigest: a.srcDigest,
 		},
 	}
 }
@@ -196,21 +454,55 @@ func newArtifact(file string) (*Artifact, error) {
 	}, nil
 }
 
-func generateManifest(config ocispec.Descriptor, name, version, arch, entry, altentry, legentry string, layers ...ocispec.Descriptor) ([]byte, error) {
+// fileNames returns base names of files as they are stored in the artifact.
+func fileNames(files []string) []string {
+	result := make([]string, 0, len(files))
+	for _, f := range files {
+		result = append(result, filepath.Base(f))
+	}
+	return result
+}
+
+func generateManifest(config ocispec.Descriptor, args PushArgs, layers ...ocispec.Descriptor) ([]byte, error) {
 	content := ocispec.Manifest{
-		ArtifactType: UnknownArtifactType,
+		ArtifactType: NetbootArtifactType,
 		MediaType:    ocispec.MediaTypeImageManifest,
 		Config:       config,
 		Layers:       layers,
+		Subject:      args.SubjectDesc,
 		Versioned:    specs.Versioned{SchemaVersion: 2},
 		Annotations: map[string]string{
-			"org.pulpproject.netboot.os.name":          name,
-			"org.pulpproject.netboot.os.version":       version,
-			"org.pulpproject.netboot.os.arch":          arch,
-			"org.pulpproject.netboot.entrypoint":       entry,
-			"org.pulpproject.netboot.altentrypoint":    altentry,
-			"org.pulpproject.netboot.legacyentrypoint": legentry,
+			AnnotationSchemaVersion:    NetbootSchemaVersion,
+			AnnotationOSName:           args.Name,
+			AnnotationOSVersion:        args.Version,
+			AnnotationOSArch:           args.Architecture,
+			AnnotationEntryPoint:       args.EntryPoint,
+			AnnotationAltEntryPoint:    args.AltEntryPoint,
+			AnnotationLegacyEntryPoint: args.LegacyEntryPoint,
 		},
 	}
+
+	if args.Kernel != "" {
+		content.Annotations[AnnotationKernel] = args.Kernel
+		content.Annotations[AnnotationInitrd] = args.Initrd
+		content.Annotations[AnnotationKernelArgs] = args.KernelArgs
+	}
+	if args.KernelVersion != "" {
+		content.Annotations[AnnotationKernelVersion] = args.KernelVersion
+	}
+	for k, v := range args.Annotation {
+		content.Annotations[k] = v
+	}
+	if len(args.FileRPM) > 0 {
+		nevras := make([]string, 0, len(args.FileRPM))
+		for _, nevra := range args.FileRPM {
+			if !slices.Contains(nevras, nevra) {
+				nevras = append(nevras, nevra)
+			}
+		}
+		slices.Sort(nevras)
+		content.Annotations[AnnotationRPMs] = strings.Join(nevras, ",")
+	}
+
 	return json.Marshal(content)
 }
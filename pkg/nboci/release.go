@@ -0,0 +1,339 @@
+package nboci
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"gopkg.in/yaml.v3"
+	"oras.land/oras-go/v2/registry"
+)
+
+// Release is a release file describing many netboot artifacts pushed at once.
+type Release struct {
+	Repository string            `yaml:"repository"`
+	Artifacts  []ReleaseArtifact `yaml:"artifacts"`
+}
+
+// ReleaseArtifact is a single artifact of a release file, fields correspond to push arguments.
+type ReleaseArtifact struct {
+	Repository       string            `yaml:"repository"`
+	Name             string            `yaml:"name"`
+	Version          string            `yaml:"version"`
+	Architecture     string            `yaml:"arch"`
+	Tags             []string          `yaml:"tags"`
+	EntryPoint       string            `yaml:"entrypoint"`
+	AltEntryPoint    string            `yaml:"alt-entrypoint"`
+	LegacyEntryPoint string            `yaml:"legacy-entrypoint"`
+	Kernel           string            `yaml:"kernel"`
+	Initrd           string            `yaml:"initrd"`
+	KernelArgs       string            `yaml:"kernel-args"`
+	Detect           bool              `yaml:"detect"`
+	FromISO          string            `yaml:"from-iso"`
+	ISOFiles         []string          `yaml:"iso-files"`
+	FromImage        string            `yaml:"from-image"`
+	Subject          string            `yaml:"subject"`
+	Attach           bool              `yaml:"attach"`
+	Index            bool              `yaml:"index"`
+	IndexTag         string            `yaml:"index-tag"`
+	Annotations      map[string]string `yaml:"annotations"`
+	Attestations     map[string]string `yaml:"attestations"`
+	Files            []ReleaseFile     `yaml:"files"`
+}
+
+// ReleaseFile is a file of an artifact, either a path or a source path with a target name.
+type ReleaseFile struct {
+	Source string `yaml:"source"`
+	Name   string `yaml:"name"`
+}
+
+func (f *ReleaseFile) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		f.Source = node.Value
+		return nil
+	}
+	// node decoding does not inherit known fields checking of the decoder
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i < len(node.Content); i += 2 {
+			if k := node.Content[i].Value; k != "source" && k != "name" {
+				return fmt.Errorf("line %d: field %s not found in file", node.Content[i].Line, k)
+			}
+		}
+	}
+
+	type plain ReleaseFile
+	return node.Decode((*plain)(f))
+}
+
+// releaseItem is a release artifact prepared for pushing.
+type releaseItem struct {
+	label     string
+	args      PushArgs
+	dir       string
+	imageDesc ocispec.Descriptor
+}
+
+// pushRelease validates all artifacts of a release file and pushes them when all are valid.
+func pushRelease(ctx context.Context, args PushArgs) {
+	if flags := artifactFlags(args); len(flags) > 0 {
+		Fatalf("%s cannot be used with --config, set them per artifact in the release file", strings.Join(flags, ", "))
+	}
+	release, err := readRelease(args.Config)
+	if err != nil {
+		FatalErr(err, "cannot read release file", args.Config)
+	}
+	if args.Repository != "" {
+		release.Repository = args.Repository
+	}
+
+	items := make([]*releaseItem, 0, len(release.Artifacts))
+	cleanup := func() {
+		for _, item := range items {
+			os.RemoveAll(item.dir)
+		}
+	}
+
+	failed := 0
+	for i, a := range release.Artifacts {
+		item := &releaseItem{
+			label: fmt.Sprintf("artifact #%d", i+1),
+			dir:   mkTempDir(),
+		}
+		items = append(items, item)
+
+		if err := item.prepare(ctx, a, release.Repository, filepath.Dir(args.Config), args); err != nil {
+			ErrorErr(err, item.label, "is invalid")
+			failed++
+			continue
+		}
+		if tag := item.duplicateTag(items[:i]); tag != "" {
+			Errorf("%s: tag %s is pushed more than once", item.label, tag)
+			failed++
+			continue
+		}
+		Debug(item.label, "is valid")
+	}
+	if failed > 0 {
+		cleanup()
+		Fatalf("%d of %d artifacts are invalid, nothing was pushed", failed, len(items))
+	}
+
+	for _, item := range items {
+		Print("pushing", item.label)
+		if err := pushArtifact(ctx, item.args, item.imageDesc); err != nil {
+			ErrorErr(err, "cannot push", item.label)
+			failed++
+			continue
+		}
+	}
+	cleanup()
+	if failed > 0 {
+		Fatalf("%d of %d artifacts failed to push", failed, len(items))
+	}
+	Printf("pushed %d artifacts\n", len(items))
+}
+
+// artifactFlags returns per-artifact push arguments given on the command line. They are not
+// applied to release artifacts, signing and verification arguments are applied to all of them.
+func artifactFlags(args PushArgs) []string {
+	flags := make([]string, 0)
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"FILE", len(args.File) > 0},
+		{"--osname", args.Name != ""},
+		{"--osversion", args.Version != ""},
+		{"--osarch", args.Architecture != ""},
+		{"--tag", args.Tag != ""},
+		{"--entrypoint", args.EntryPoint != ""},
+		{"--alt-entrypoint", args.AltEntryPoint != ""},
+		{"--legacy-entrypoint", args.LegacyEntryPoint != ""},
+		{"--kernel", args.Kernel != ""},
+		{"--initrd", args.Initrd != ""},
+		{"--kernel-args", args.KernelArgs != ""},
+		{"--detect", args.Detect},
+		{"--from-iso", args.FromISO != ""},
+		{"--iso-file", len(args.ISOFile) > 0},
+		{"--from-image", args.FromImage != ""},
+		{"--subject", args.Subject != ""},
+		{"--attach", args.Attach},
+		{"--index", args.Index},
+		{"--index-tag", args.IndexTag != ""},
+		{"--annotation", len(args.Annotation) > 0},
+		{"--attestation", len(args.Attestation) > 0},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+
+	return flags
+}
+
+// duplicateTag returns a tag of the item which is used more than once by the item or by other
+// items in the same repository.
+func (item *releaseItem) duplicateTag(others []*releaseItem) string {
+	tags := append([]string{item.args.Tag}, item.args.ExtraTags...)
+	for i, tag := range tags {
+		if slices.Contains(tags[:i], tag) {
+			return tag
+		}
+		for _, other := range others {
+			if other.args.Repository == item.args.Repository &&
+				(other.args.Tag == tag || slices.Contains(other.args.ExtraTags, tag)) {
+				return tag
+			}
+		}
+	}
+
+	return ""
+}
+
+func readRelease(filename string) (*Release, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var release Release
+	if err := decodeYAML(data, &release); err != nil {
+		return nil, err
+	}
+	if len(release.Artifacts) == 0 {
+		return nil, errors.New("no artifacts in release file")
+	}
+
+	return &release, nil
+}
+
+// decodeYAML decodes a YAML document and fails on unknown keys, so a typo in a release, sync or
+// policy file is never silently ignored.
+func decodeYAML(data []byte, v any) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	return nil
+}
+
+// prepare converts release artifact into push arguments and validates them. Files with target
+// names are linked into the temporary directory, relative paths are relative to the release file.
+func (item *releaseItem) prepare(ctx context.Context, a ReleaseArtifact, repository, base string, cli PushArgs) error {
+	if a.Repository == "" {
+		a.Repository = repository
+	}
+	if a.Repository == "" {
+		return errors.New("repository is required")
+	}
+	if _, err := registry.ParseReference(a.Repository); err != nil {
+		return err
+	}
+
+	item.args = PushArgs{
+		Plain:             cli.Plain,
+		NetbootConfig:     cli.NetbootConfig,
+		SignKey:           cli.SignKey,
+		IdentityToken:     cli.IdentityToken,
+		FulcioURL:         cli.FulcioURL,
+		RekorURL:          cli.RekorURL,
+		NoTlog:            cli.NoTlog,
+		Yes:               cli.Yes,
+		SecureBootCA:      cli.SecureBootCA,
+		RequireSecureBoot: cli.RequireSecureBoot,
+		SBATLevel:         cli.SBATLevel,
+		RequireSBAT:       cli.RequireSBAT,
+		Repository:        a.Repository,
+		Name:              a.Name,
+		Version:           a.Version,
+		Architecture:      a.Architecture,
+		EntryPoint:        a.EntryPoint,
+		AltEntryPoint:     a.AltEntryPoint,
+		LegacyEntryPoint:  a.LegacyEntryPoint,
+		Kernel:            a.Kernel,
+		Initrd:            a.Initrd,
+		KernelArgs:        a.KernelArgs,
+		Detect:            a.Detect,
+		FromISO:           releasePath(base, a.FromISO),
+		ISOFile:           a.ISOFiles,
+		FromImage:         a.FromImage,
+		Subject:           a.Subject,
+		Attach:            a.Attach,
+		Index:             a.Index,
+		IndexTag:          a.IndexTag,
+		Annotation:        a.Annotations,
+	}
+	for t, f := range a.Attestations {
+		if item.args.Attestation == nil {
+			item.args.Attestation = make(map[string]string)
+		}
+		item.args.Attestation[t] = releasePath(base, f)
+	}
+	if len(a.Tags) > 0 {
+		item.args.Tag = a.Tags[0]
+		item.args.ExtraTags = a.Tags[1:]
+	}
+	if a.FromImage != "" {
+		if _, err := registry.ParseReference(a.FromImage); err != nil {
+			return err
+		}
+	}
+
+	names := make(map[string]bool, len(a.Files))
+	for _, f := range a.Files {
+		if f.Source == "" {
+			return errors.New("file source is required")
+		}
+		source := releasePath(base, f.Source)
+		name := f.Name
+		if name == "" {
+			name = filepath.Base(source)
+		}
+		if names[name] {
+			return fmt.Errorf("file name %s is used more than once", name)
+		}
+		names[name] = true
+		if f.Name == "" || f.Name == filepath.Base(source) {
+			item.args.File = append(item.args.File, source)
+			continue
+		}
+
+		if filepath.Base(f.Name) != f.Name {
+			return fmt.Errorf("file name %s must not contain directories", f.Name)
+		}
+		abs, err := filepath.Abs(source)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(item.dir, f.Name)
+		if err := os.Symlink(abs, target); err != nil {
+			return err
+		}
+		item.args.File = append(item.args.File, target)
+	}
+
+	var err error
+	item.imageDesc, err = preparePush(ctx, &item.args, item.dir)
+	if err != nil {
+		return err
+	}
+	item.label = fmt.Sprintf("%s:%s", item.args.Repository, item.args.Tag)
+
+	return nil
+}
+
+func releasePath(base, p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(base, p)
+}
@@ -0,0 +1,52 @@
+package nboci
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestArtifactFlags(t *testing.T) {
+	args := PushArgs{
+		Config:       "release.yaml",
+		Repository:   "ghcr.io/lzap/netboot",
+		SecureBootCA: []string{"ca.pem"},
+		RequireSBAT:  true,
+		SignKey:      "cosign.key",
+	}
+	if flags := artifactFlags(args); len(flags) != 0 {
+		t.Fatalf("common arguments reported as per-artifact: %v", flags)
+	}
+
+	args.KernelArgs = "console=ttyS0"
+	args.Attestation = map[string]string{"spdxjson": "sbom.json"}
+	args.File = []string{"shim.efi"}
+	if flags := artifactFlags(args); !slices.Equal(flags, []string{"FILE", "--kernel-args", "--attestation"}) {
+		t.Fatalf("unexpected flags %v", flags)
+	}
+}
+
+func TestReleaseDuplicateTag(t *testing.T) {
+	item := func(repository, tag string, extra ...string) *releaseItem {
+		return &releaseItem{args: PushArgs{Repository: repository, Tag: tag, ExtraTags: extra}}
+	}
+	tests := []struct {
+		name   string
+		item   *releaseItem
+		others []*releaseItem
+		tag    string
+	}{
+		{"unique", item("r", "a", "b"), []*releaseItem{item("r", "c", "d")}, ""},
+		{"main tag", item("r", "a"), []*releaseItem{item("r", "a")}, "a"},
+		{"extra tag of other", item("r", "a", "latest"), []*releaseItem{item("r", "c", "latest")}, "latest"},
+		{"main tag as extra tag", item("r", "a"), []*releaseItem{item("r", "c", "a")}, "a"},
+		{"repeated extra tag", item("r", "a", "b", "b"), nil, "b"},
+		{"other repository", item("r", "a", "latest"), []*releaseItem{item("s", "a", "latest")}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tag := tt.item.duplicateTag(tt.others); tag != tt.tag {
+				t.Fatalf("duplicate tag %q, expected %q", tag, tt.tag)
+			}
+		})
+	}
+}
@@ -0,0 +1,334 @@
+package nboci
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// RPM header tags
+const (
+	rpmTagName              = 1000
+	rpmTagVersion           = 1001
+	rpmTagRelease           = 1002
+	rpmTagEpoch             = 1003
+	rpmTagArch              = 1022
+	rpmTagPayloadCompressor = 1125
+)
+
+// RPM header data types
+const (
+	rpmTypeInt32  = 4
+	rpmTypeString = 6
+)
+
+var rpmLeadMagic = []byte{0xed, 0xab, 0xee, 0xdb}
+var rpmHeaderMagic = []byte{0x8e, 0xad, 0xe8, 0x01}
+
+// rpmBootFiles maps known boot binaries in RPM packages to target file names.
+var rpmBootFiles = map[string]string{
+	"shimx64.efi":  "shim.efi",
+	"shimaa64.efi": "shim.efi",
+	"mmx64.efi":    "mmx64.efi",
+	"mmaa64.efi":   "mmaa64.efi",
+	"grubx64.efi":  "grubx64.efi",
+	"grubaa64.efi": "grubaa64.efi",
+	"pxelinux.0":   "pxelinux.0",
+	"lpxelinux.0":  "lpxelinux.0",
+	"ldlinux.c32":  "ldlinux.c32",
+}
+
+// RPMPackage is an RPM package header with payload reader positioned at the compressed payload.
+type RPMPackage struct {
+	Name       string
+	Epoch      int32
+	Version    string
+	Release    string
+	Arch       string
+	Compressor string
+	payload    io.Reader
+}
+
+func (p *RPMPackage) NEVRA() string {
+	if p.Epoch > 0 {
+		return fmt.Sprintf("%s-%d:%s-%s.%s", p.Name, p.Epoch, p.Version, p.Release, p.Arch)
+	}
+	return fmt.Sprintf("%s-%s-%s.%s", p.Name, p.Version, p.Release, p.Arch)
+}
+
+// IsRPM returns true when file starts with RPM lead magic.
+func IsRPM(filename string) bool {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return bytes.Equal(magic, rpmLeadMagic)
+}
+
+type rpmHeader struct {
+	index map[int32][4]int32
+	store []byte
+}
+
+func readRPMHeader(r io.Reader) (*rpmHeader, int, error) {
+	intro := make([]byte, 16)
+	if _, err := io.ReadFull(r, intro); err != nil {
+		return nil, 0, err
+	}
+	if !bytes.Equal(intro[0:4], rpmHeaderMagic) {
+		return nil, 0, errors.New("invalid RPM header magic")
+	}
+
+	nindex := int(binary.BigEndian.Uint32(intro[8:]))
+	hsize := int(binary.BigEndian.Uint32(intro[12:]))
+	if nindex > 100000 || hsize > 256*1024*1024 {
+		return nil, 0, errors.New("RPM header too big")
+	}
+
+	data := make([]byte, nindex*16+hsize)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, 0, err
+	}
+
+	h := &rpmHeader{
+		index: make(map[int32][4]int32, nindex),
+		store: data[nindex*16:],
+	}
+	for i := 0; i < nindex; i++ {
+		e := data[i*16 : (i+1)*16]
+		tag := int32(binary.BigEndian.Uint32(e[0:]))
+		h.index[tag] = [4]int32{
+			tag,
+			int32(binary.BigEndian.Uint32(e[4:])),
+			int32(binary.BigEndian.Uint32(e[8:])),
+			int32(binary.BigEndian.Uint32(e[12:])),
+		}
+	}
+
+	return h, 16 + len(data), nil
+}
+
+func (h *rpmHeader) String(tag int32) string {
+	e, ok := h.index[tag]
+	if !ok || e[1] != rpmTypeString || int(e[2]) >= len(h.store) {
+		return ""
+	}
+
+	s := h.store[e[2]:]
+	if end := bytes.IndexByte(s, 0); end >= 0 {
+		s = s[:end]
+	}
+	return string(s)
+}
+
+func (h *rpmHeader) Int32(tag int32) int32 {
+	e, ok := h.index[tag]
+	if !ok || e[1] != rpmTypeInt32 || int(e[2])+4 > len(h.store) {
+		return 0
+	}
+
+	return int32(binary.BigEndian.Uint32(h.store[e[2]:]))
+}
+
+// ReadRPM reads RPM lead, signature and main header.
+func ReadRPM(r io.Reader) (*RPMPackage, error) {
+	lead := make([]byte, 96)
+	if _, err := io.ReadFull(r, lead); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(lead[0:4], rpmLeadMagic) {
+		return nil, errors.New("not an RPM package")
+	}
+
+	_, size, err := readRPMHeader(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read signature header: %w", err)
+	}
+	// signature header is aligned to 8 bytes
+	if pad := (8 - size%8) % 8; pad > 0 {
+		if _, err := io.CopyN(io.Discard, r, int64(pad)); err != nil {
+			return nil, err
+		}
+	}
+
+	h, _, err := readRPMHeader(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read main header: %w", err)
+	}
+
+	return &RPMPackage{
+		Name:       h.String(rpmTagName),
+		Epoch:      h.Int32(rpmTagEpoch),
+		Version:    h.String(rpmTagVersion),
+		Release:    h.String(rpmTagRelease),
+		Arch:       h.String(rpmTagArch),
+		Compressor: h.String(rpmTagPayloadCompressor),
+		payload:    r,
+	}, nil
+}
+
+// Payload returns decompressed cpio payload reader, it must be closed to release the decoder.
+func (p *RPMPackage) Payload() (io.ReadCloser, error) {
+	switch p.Compressor {
+	case "", "gzip":
+		return gzip.NewReader(p.payload)
+	case "bzip2":
+		return io.NopCloser(bzip2.NewReader(p.payload)), nil
+	case "xz":
+		r, err := xz.NewReader(p.payload)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(r), nil
+	case "lzma":
+		r, err := lzma.NewReader(p.payload)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(r), nil
+	case "zstd":
+		zr, err := zstd.NewReader(p.payload)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+
+	return nil, fmt.Errorf("unsupported payload compressor %s", p.Compressor)
+}
+
+// extractRPMs replaces RPM packages in the list of pushed files with known boot binaries
+// extracted into dir and records package NEVRAs.
+func extractRPMs(args *PushArgs, dir string) error {
+	files := make([]string, 0, len(args.File))
+	for _, f := range args.File {
+		if !IsRPM(f) {
+			files = append(files, f)
+			continue
+		}
+
+		nevra, extracted, err := extractRPM(f, dir)
+		if err != nil {
+			return fmt.Errorf("cannot extract RPM %s: %w", f, err)
+		}
+		if len(extracted) == 0 {
+			return fmt.Errorf("no known boot files in RPM %s", f)
+		}
+
+		if args.FileRPM == nil {
+			args.FileRPM = make(map[string]string)
+		}
+		for _, e := range extracted {
+			args.FileRPM[filepath.Base(e)] = nevra
+		}
+		files = append(files, extracted...)
+	}
+
+	args.File = files
+	return nil
+}
+
+func extractRPM(filename, dir string) (string, []string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	pkg, err := ReadRPM(bufio.NewReader(f))
+	if err != nil {
+		return "", nil, err
+	}
+	nevra := pkg.NEVRA()
+	Debug("extracting boot files from", nevra)
+
+	payload, err := pkg.Payload()
+	if err != nil {
+		return "", nil, err
+	}
+	defer payload.Close()
+	cr := NewCpioReader(bufio.NewReader(payload))
+
+	result := make([]string, 0)
+	// targets of hardlinks waiting for the last link which carries the data
+	links := make(map[[3]int64][]string)
+	for {
+		h, err := cr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return "", nil, err
+		}
+		if !h.IsRegular() {
+			continue
+		}
+
+		targets := make([]string, 0, 1)
+		inode := [3]int64{h.DevMajor, h.DevMinor, h.Inode}
+		if h.Links > 1 {
+			targets = append(targets, links[inode]...)
+			delete(links, inode)
+		}
+		if target, ok := rpmBootFiles[path.Base(h.Name)]; ok {
+			dest := filepath.Join(dir, target)
+			if slices.Contains(result, dest) || slices.Contains(targets, dest) {
+				Debug("skipping duplicate", h.Name)
+			} else {
+				Debug("extracting", strings.TrimPrefix(h.Name, "."), "->", target)
+				targets = append(targets, dest)
+			}
+		}
+		if len(targets) == 0 {
+			continue
+		}
+		if h.Links > 1 && h.Size == 0 {
+			links[inode] = targets
+			continue
+		}
+
+		if err := writeFile(targets[0], cr); err != nil {
+			return "", nil, err
+		}
+		for _, t := range targets[1:] {
+			if err := copyFile(targets[0], t); err != nil {
+				return "", nil, err
+			}
+		}
+		result = append(result, targets...)
+	}
+	if len(links) > 0 {
+		return "", nil, errors.New("hardlinked boot file without data")
+	}
+
+	return nevra, result, nil
+}
+
+func copyFile(src, dest string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return writeFile(dest, f)
+}
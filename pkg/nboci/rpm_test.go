@@ -0,0 +1,142 @@
+package nboci
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// testRPMHeader returns an RPM header structure with string and int32 tags.
+func testRPMHeader(strs map[int]string, ints map[int]int32) []byte {
+	var index, store bytes.Buffer
+	entry := func(tag, typ, offset int) {
+		for _, v := range []int{tag, typ, offset, 1} {
+			_ = binary.Write(&index, binary.BigEndian, uint32(v))
+		}
+	}
+	for tag, v := range ints {
+		entry(tag, rpmTypeInt32, store.Len())
+		_ = binary.Write(&store, binary.BigEndian, v)
+	}
+	for tag, v := range strs {
+		entry(tag, rpmTypeString, store.Len())
+		store.WriteString(v + "\x00")
+	}
+
+	h := append([]byte{}, rpmHeaderMagic...)
+	h = append(h, 0, 0, 0, 0)
+	h = binary.BigEndian.AppendUint32(h, uint32(len(strs)+len(ints)))
+	h = binary.BigEndian.AppendUint32(h, uint32(store.Len()))
+	h = append(h, index.Bytes()...)
+	return append(h, store.Bytes()...)
+}
+
+// writeTestRPM writes an RPM package with a zstd compressed cpio payload.
+func writeTestRPM(t *testing.T, epoch int32, entries ...testCpioEntry) string {
+	t.Helper()
+	lead := make([]byte, 96)
+	copy(lead, rpmLeadMagic)
+	// signature header is padded to 8 bytes
+	sig := testRPMHeader(map[int]string{1000: "x"}, nil)
+	rpm := append(lead, sig...)
+	rpm = append(rpm, make([]byte, (8-len(sig)%8)%8)...)
+	ints := map[int]int32{}
+	if epoch > 0 {
+		ints[rpmTagEpoch] = epoch
+	}
+	rpm = append(rpm, testRPMHeader(map[int]string{
+		rpmTagName:              "shim-x64",
+		rpmTagVersion:           "15.8",
+		rpmTagRelease:           "3",
+		rpmTagArch:              "x86_64",
+		rpmTagPayloadCompressor: "zstd",
+	}, ints)...)
+
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpm = enc.EncodeAll(writeTestCpio(entries...), rpm)
+
+	return writeTestFile(t, "shim.rpm", rpm)
+}
+
+func TestExtractRPM(t *testing.T) {
+	filename := writeTestRPM(t, 1,
+		testCpioEntry{name: "./boot/efi/EFI/fedora", mode: 040755},
+		testCpioEntry{name: "./boot/efi/EFI/fedora/mmx64.efi", mode: cpioModeRegular | 0700, data: "mokmanager"},
+		testCpioEntry{name: "./boot/efi/EFI/fedora/shim.efi", mode: cpioModeRegular | 0700, data: "ignored"},
+		// hardlinks carry the data on the last link only
+		testCpioEntry{name: "./boot/efi/EFI/BOOT/BOOTX64.EFI", mode: cpioModeRegular | 0700, ino: 100, links: 2},
+		testCpioEntry{name: "./boot/efi/EFI/fedora/shimx64.efi", mode: cpioModeRegular | 0700, ino: 100, links: 2, data: "shim"},
+		testCpioEntry{name: "./boot/efi/EFI/fedora/grubx64.efi", mode: cpioModeRegular | 0700, ino: 200, links: 2},
+		testCpioEntry{name: "./usr/lib/grub/grub.efi", mode: cpioModeRegular | 0700, ino: 200, links: 2, data: "grub"},
+		testCpioEntry{name: "./boot/efi/EFI/fedora/mmx64.efi.link", mode: cpioModeSymlink | 0777, data: "mmx64.efi"},
+	)
+	dir := t.TempDir()
+
+	nevra, files, err := extractRPM(filename, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nevra != "shim-x64-1:15.8-3.x86_64" {
+		t.Fatalf("unexpected NEVRA %s", nevra)
+	}
+	expected := map[string]string{"mmx64.efi": "mokmanager", "shim.efi": "shim", "grubx64.efi": "grub"}
+	if len(files) != len(expected) {
+		t.Fatalf("extracted %v", files)
+	}
+	for name, data := range expected {
+		if !slices.Contains(files, filepath.Join(dir, name)) {
+			t.Fatalf("%s not extracted: %v", name, files)
+		}
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != data {
+			t.Fatalf("%s has content %q, expected %q", name, content, data)
+		}
+	}
+}
+
+func TestExtractRPMErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []testCpioEntry
+		err     string
+	}{
+		{"hardlink without data", []testCpioEntry{
+			{name: "./boot/efi/EFI/fedora/shimx64.efi", mode: cpioModeRegular, ino: 100, links: 2},
+		}, "hardlinked boot file without data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := extractRPM(writeTestRPM(t, 0, tt.entries...), t.TempDir())
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+
+	if _, _, err := extractRPM(writeTestFile(t, "x.rpm", make([]byte, 200)), t.TempDir()); err == nil || !strings.Contains(err.Error(), "not an RPM package") {
+		t.Fatalf("expected error for file without lead, got %v", err)
+	}
+}
+
+func TestRPMNEVRA(t *testing.T) {
+	p := RPMPackage{Name: "grub2-efi-x64", Version: "2.06", Release: "100.fc40", Arch: "x86_64"}
+	if n := p.NEVRA(); n != "grub2-efi-x64-2.06-100.fc40.x86_64" {
+		t.Fatalf("unexpected NEVRA %s", n)
+	}
+	p.Epoch = 1
+	if n := p.NEVRA(); n != "grub2-efi-x64-1:2.06-100.fc40.x86_64" {
+		t.Fatalf("unexpected NEVRA %s", n)
+	}
+}
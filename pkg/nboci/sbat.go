@@ -0,0 +1,169 @@
+package nboci
+
+import (
+	"bufio"
+	"bytes"
+	"debug/pe"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// sbatEntry is a component line of the .sbat section of an EFI image, which is followed by vendor
+// name, vendor package name, vendor version and vendor URL.
+type sbatEntry struct {
+	Component  string
+	Generation int
+}
+
+// SBATLevel maps components to minimum generations, like the SbatLevel UEFI variable of shim.
+type SBATLevel map[string]int
+
+// loadSBATLevel reads a minimum SBAT level file with "component,generation" lines, e.g.
+//
+//	sbat,1,2024010900
+//	shim,4
+//	grub,4
+//	grub.proxmox,2
+//
+// Empty lines and lines starting with # are ignored. Nil is returned when there is no file.
+func loadSBATLevel(filename string) (SBATLevel, error) {
+	if filename == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	level := make(SBATLevel)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected component,generation", n)
+		}
+		gen, err := strconv.Atoi(strings.TrimSpace(fields[1]))
+		if err != nil || gen < 1 {
+			return nil, fmt.Errorf("line %d: invalid generation %q", n, fields[1])
+		}
+		level[strings.TrimSpace(fields[0])] = gen
+	}
+
+	return level, scanner.Err()
+}
+
+// readSBAT returns the raw .sbat section of a PE file. Nil is returned for files which are not
+// PE images or do not have the section.
+func readSBAT(filename string) ([]byte, error) {
+	if ok, err := isPEFile(filename); err != nil || !ok {
+		return nil, err
+	}
+
+	// only headers and the section are read
+	f, err := pe.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := f.Section(".sbat")
+	if s == nil {
+		return nil, nil
+	}
+	// section is padded with zeros to the file alignment
+	raw, err := s.Data()
+	if err != nil {
+		return nil, err
+	}
+	if i := bytes.IndexByte(raw, 0); i >= 0 {
+		raw = raw[:i]
+	}
+
+	return bytes.TrimSpace(raw), nil
+}
+
+// parseSBAT parses CSV data of a .sbat section.
+func parseSBAT(data []byte) ([]sbatEntry, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]sbatEntry, 0, len(records))
+	for _, rec := range records {
+		if len(rec) < 2 {
+			return nil, fmt.Errorf("invalid SBAT entry %q", strings.Join(rec, ","))
+		}
+		gen, err := strconv.Atoi(rec[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid SBAT generation of %s: %w", rec[0], err)
+		}
+		entries = append(entries, sbatEntry{Component: rec[0], Generation: gen})
+	}
+
+	return entries, nil
+}
+
+// readFileSBAT returns the .sbat section of a file. Read errors are reported as warnings unless
+// SBAT is required.
+func readFileSBAT(filename, name string, require bool) ([]byte, error) {
+	data, err := readSBAT(filename)
+	if err != nil {
+		err = fmt.Errorf("cannot read SBAT of %s: %w", name, err)
+		if require {
+			return nil, err
+		}
+		ErrorErr(err, "warning")
+		return nil, nil
+	}
+
+	return data, nil
+}
+
+// revoked returns errors for components of entries older than the minimum level.
+func (level SBATLevel) revoked(entries []sbatEntry) error {
+	errs := make([]error, 0)
+	for _, e := range entries {
+		if minGen, ok := level[e.Component]; ok && e.Generation < minGen {
+			errs = append(errs, fmt.Errorf("%s generation %d is revoked, minimum is %d", e.Component, e.Generation, minGen))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkSBAT compares SBAT generations of .sbat section data of an EFI file against the minimum
+// level when it is given. Files without SBAT data are not checked. Revocations are reported as
+// warnings unless SBAT is required.
+func checkSBAT(data []byte, name string, level SBATLevel, require bool) error {
+	if level == nil || len(data) == 0 {
+		return nil
+	}
+
+	entries, err := parseSBAT(data)
+	if err == nil {
+		err = level.revoked(entries)
+	}
+	if err != nil {
+		err = fmt.Errorf("%s would be refused by SBAT: %w", name, err)
+		if require {
+			return err
+		}
+		ErrorErr(err, "warning")
+		return nil
+	}
+	Debug("SBAT level satisfied by", name)
+
+	return nil
+}
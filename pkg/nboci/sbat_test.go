@@ -0,0 +1,145 @@
+package nboci
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testSBAT = "sbat,1,SBAT Version,sbat,1,https://github.com/rhboot/shim/blob/main/SBAT.md\n" +
+	"shim,3,UEFI shim,shim,15.7,https://github.com/rhboot/shim\n" +
+	"shim.fedora,1,Fedora,shim,15.7,https://src.fedoraproject.org/rpms/shim\n"
+
+func TestParseSBAT(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		entries []sbatEntry
+		err     string
+	}{
+		{"shim", testSBAT, []sbatEntry{{"sbat", 1}, {"shim", 3}, {"shim.fedora", 1}}, ""},
+		{"quoted vendor", "grub,4,\"Free Software Foundation, Inc.\",grub,2.06,https://www.gnu.org/software/grub/\n", []sbatEntry{{"grub", 4}}, ""},
+		{"component only", "grub\n", nil, "invalid SBAT entry"},
+		{"invalid generation", "grub,four\n", nil, "invalid SBAT generation of grub"},
+		{"unterminated quote", "grub,4,\"GNU\n", nil, "quote"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseSBAT([]byte(tt.data))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(entries, tt.entries) {
+				t.Fatalf("parsed %v", entries)
+			}
+		})
+	}
+}
+
+func TestLoadSBATLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		level SBATLevel
+		err   string
+	}{
+		{"level", "# shim 15.8\nsbat,1,2024010900\n\nshim, 4\ngrub,4\n", SBATLevel{"sbat": 1, "shim": 4, "grub": 4}, ""},
+		{"missing generation", "shim\n", nil, "line 1: expected component,generation"},
+		{"zero generation", "shim,4\ngrub,0\n", nil, "line 2: invalid generation"},
+		{"invalid generation", "grub,x\n", nil, "invalid generation \"x\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			level, err := loadSBATLevel(writeTestFile(t, "level.csv", []byte(tt.data)))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(level) != len(tt.level) {
+				t.Fatalf("loaded %v", level)
+			}
+			for c, gen := range tt.level {
+				if level[c] != gen {
+					t.Fatalf("loaded %v", level)
+				}
+			}
+		})
+	}
+
+	if level, err := loadSBATLevel(""); level != nil || err != nil {
+		t.Fatalf("level without file %v: %v", level, err)
+	}
+}
+
+func TestSBATRevoked(t *testing.T) {
+	entries := []sbatEntry{{"sbat", 1}, {"shim", 3}, {"shim.fedora", 1}}
+	tests := []struct {
+		name  string
+		level SBATLevel
+		err   string
+	}{
+		{"equal generation", SBATLevel{"sbat": 1, "shim": 3}, ""},
+		{"older level", SBATLevel{"shim": 2}, ""},
+		{"other component", SBATLevel{"grub": 4}, ""},
+		{"revoked", SBATLevel{"shim": 4}, "shim generation 3 is revoked, minimum is 4"},
+		{"vendor revoked", SBATLevel{"shim": 3, "shim.fedora": 2}, "shim.fedora generation 1 is revoked"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.level.revoked(entries)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestReadSBAT(t *testing.T) {
+	shim := writeTestFile(t, "shim.efi", buildTestPE(testPESection{".text", "code"}, testPESection{".sbat", testSBAT}))
+	data, err := readSBAT(shim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// section padding is not returned
+	if string(data) != strings.TrimSpace(testSBAT) {
+		t.Fatalf("read %q", data)
+	}
+
+	for name, content := range map[string][]byte{
+		"grubx64.efi": buildTestPE(testPESection{".text", "code"}),
+		"vmlinuz":     []byte(strings.Repeat("k", 512)),
+	} {
+		data, err := readSBAT(writeTestFile(t, name, content))
+		if err != nil || data != nil {
+			t.Fatalf("%s has SBAT %q: %v", name, data, err)
+		}
+	}
+
+	level := SBATLevel{"shim": 4}
+	if err := checkSBAT(data, "shim.efi", level, true); err == nil || !strings.Contains(err.Error(), "shim.efi would be refused by SBAT") {
+		t.Fatalf("expected revocation, got %v", err)
+	}
+	if err := checkSBAT(data, "shim.efi", level, false); err != nil {
+		t.Fatalf("revocation without --require-sbat is an error: %v", err)
+	}
+	if err := checkSBAT(nil, "vmlinuz", level, true); err != nil {
+		t.Fatalf("file without SBAT is checked: %v", err)
+	}
+}
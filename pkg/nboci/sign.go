@@ -0,0 +1,95 @@
+package nboci
+
+import (
+	"context"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/sigstore/cosign/v2/cmd/cosign/cli/generate"
+	"github.com/sigstore/cosign/v2/cmd/cosign/cli/options"
+	"github.com/sigstore/cosign/v2/cmd/cosign/cli/sign"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/credentials"
+)
+
+// storeKeychain resolves registry credentials for sigstore libraries from the nboci credential
+// store, falling back to the default docker keychain.
+type storeKeychain struct {
+	store credentials.Store
+}
+
+func (k storeKeychain) Resolve(r authn.Resource) (authn.Authenticator, error) {
+	cred, err := k.store.Get(context.Background(), r.RegistryStr())
+	if err != nil {
+		return nil, err
+	}
+	if cred == auth.EmptyCredential {
+		return authn.DefaultKeychain.Resolve(r)
+	}
+
+	return authn.FromConfig(authn.AuthConfig{
+		Username:      cred.Username,
+		Password:      cred.Password,
+		IdentityToken: cred.RefreshToken,
+		RegistryToken: cred.AccessToken,
+	}), nil
+}
+
+// registryOptions returns sigstore registry options with nboci credentials.
+func registryOptions(plain bool) options.RegistryOptions {
+	return options.RegistryOptions{
+		AllowHTTPRegistry: plain,
+		Keychain:          storeKeychain{store: NewStore()},
+	}
+}
+
+// nameOptions returns reference parsing options for sigstore libraries.
+func nameOptions(plain bool) []name.Option {
+	if plain {
+		return []name.Option{name.Insecure}
+	}
+	return nil
+}
+
+// isSigning returns true when push arguments request a signature.
+func (args PushArgs) isSigning() bool {
+	return args.SignKey != "" || args.IdentityToken != ""
+}
+
+// signDigest signs a manifest or index by digest reference with a cosign key, or keyless with
+// an OIDC identity token, and uploads the signature into the repository.
+func signDigest(ref string, args PushArgs) error {
+	ro := &options.RootOptions{Timeout: options.DefaultTimeout}
+	so := options.SignOptions{
+		Key:              args.SignKey,
+		Upload:           true,
+		TlogUpload:       !args.NoTlog,
+		SkipConfirmation: args.Yes,
+		Registry:         args.registryOptions(),
+		Rekor:            options.RekorOptions{URL: args.RekorURL},
+		Fulcio:           options.FulcioOptions{URL: args.FulcioURL, IdentityToken: args.IdentityToken},
+	}
+
+	Debug("signing", ref)
+	return sign.SignCmd(ro, args.keyOpts(), so, []string{ref})
+}
+
+// keyOpts returns cosign signing key, or keyless identity token with Fulcio and Rekor URLs.
+func (args PushArgs) keyOpts() options.KeyOpts {
+	return options.KeyOpts{
+		KeyRef:           args.SignKey,
+		PassFunc:         generate.GetPass,
+		IDToken:          args.IdentityToken,
+		FulcioURL:        args.FulcioURL,
+		RekorURL:         args.RekorURL,
+		SkipConfirmation: args.Yes,
+	}
+}
+
+// registryOptions returns sigstore registry options for uploading signatures and attestations.
+func (args PushArgs) registryOptions() options.RegistryOptions {
+	ro := registryOptions(args.Plain)
+	ro.AllowInsecure = args.Plain
+
+	return ro
+}
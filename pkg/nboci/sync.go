@@ -0,0 +1,205 @@
+package nboci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"oras.land/oras-go/v2/registry"
+)
+
+// SyncConfig is a sync file describing sources pulled by a single pull invocation.
+type SyncConfig struct {
+	Destination        string       `yaml:"destination"`
+	Plain              bool         `yaml:"plain"`
+	VerificationPolicy string       `yaml:"verification-policy"`
+	Sources            []SyncSource `yaml:"sources"`
+}
+
+// SyncSource is a repository or a container image with filters, fields correspond to pull
+// arguments.
+type SyncSource struct {
+	Repository  string   `yaml:"repository"`
+	ForImage    string   `yaml:"for-image"`
+	Tags        []string `yaml:"tags"`
+	OS          []string `yaml:"os"`
+	Arch        []string `yaml:"arch"`
+	Version     string   `yaml:"version"`
+	Latest      int      `yaml:"latest"`
+	Destination string   `yaml:"destination"`
+	Plain       *bool    `yaml:"plain"`
+
+	RequireAttestations []string `yaml:"require-attestations"`
+
+	VerifierConfig `yaml:",inline"`
+}
+
+// pullConfig validates all sources of a sync file and pulls them, reporting what each source
+// contributed.
+func pullConfig(ctx context.Context, args PullArgs) {
+	config, err := readSyncConfig(args.Config)
+	if err != nil {
+		FatalErr(err, "cannot read sync file", args.Config)
+	}
+	base := filepath.Dir(args.Config)
+	if config.Destination == "" {
+		config.Destination = args.Destination
+	} else {
+		config.Destination = releasePath(base, config.Destination)
+	}
+	if config.VerificationPolicy == "" {
+		config.VerificationPolicy = args.VerificationPolicy
+	} else {
+		config.VerificationPolicy = releasePath(base, config.VerificationPolicy)
+	}
+	args.SecureBoot, err = loadSecureBootCAs(args.SecureBootCA)
+	if err != nil {
+		FatalErr(err, "cannot load Secure Boot certificates")
+	}
+	args.SBAT, err = loadSBATLevel(args.SBATLevel)
+	if err != nil {
+		FatalErr(err, "cannot load SBAT level")
+	}
+	args.Checksums, err = loadChecksumsSigner(ctx, args.ChecksumsKey)
+	if err != nil {
+		Fatal(err.Error())
+	}
+
+	sources := make([]PullArgs, 0, len(config.Sources))
+	invalid := 0
+	for i, s := range config.Sources {
+		pa, err := s.pullArgs(config, base, args)
+		if err != nil {
+			ErrorErr(err, fmt.Sprintf("source #%d is invalid", i+1))
+			invalid++
+			continue
+		}
+		sources = append(sources, pa)
+	}
+	if invalid > 0 {
+		Fatalf("%d of %d sources are invalid, nothing was pulled", invalid, len(config.Sources))
+	}
+
+	failed := 0
+	for _, pa := range sources {
+		label := pa.Source
+		if pa.ForImage != "" {
+			label = "image " + pa.ForImage
+		}
+		Print("syncing", label, "to", pa.Destination)
+
+		pa.Stats = &PullStats{}
+		err := pull(ctx, pa)
+		if err != nil {
+			ErrorErr(err, "cannot sync", label)
+			failed++
+		}
+		Printf("%s: %d artifacts, %d files downloaded, %d files up to date\n", label, len(pa.Stats.Artifacts), pa.Stats.Downloaded, pa.Stats.Unchanged)
+		for _, a := range pa.Stats.Artifacts {
+			Debug("  ", a)
+		}
+	}
+	if failed > 0 {
+		Fatalf("%d of %d sources failed", failed, len(sources))
+	}
+}
+
+func readSyncConfig(filename string) (*SyncConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var config SyncConfig
+	if err := decodeYAML(data, &config); err != nil {
+		return nil, err
+	}
+	if len(config.Sources) == 0 {
+		return nil, errors.New("no sources in sync file")
+	}
+
+	return &config, nil
+}
+
+// pullArgs validates the source and converts it into pull arguments. Relative paths are relative
+// to the sync file, offline mode and transparency log keys are taken from command line. Sources
+// without verification fields or required attestations use those of the command line.
+func (s SyncSource) pullArgs(config *SyncConfig, base string, cli PullArgs) (PullArgs, error) {
+	pa := PullArgs{
+		Source:      s.Repository,
+		ForImage:    s.ForImage,
+		Destination: config.Destination,
+		Arch:        s.Arch,
+		OS:          s.OS,
+		TagPattern:  s.Tags,
+		Version:     s.Version,
+		Latest:      s.Latest,
+		Plain:       cli.Plain || config.Plain,
+
+		VerificationPolicy: config.VerificationPolicy,
+		Offline:            cli.Offline,
+		RekorURL:           cli.RekorURL,
+		RekorPublicKey:     cli.RekorPublicKey,
+		CTLogPublicKey:     cli.CTLogPublicKey,
+		RequireAttestation: s.RequireAttestations,
+		SecureBoot:         cli.SecureBoot,
+		RequireSecureBoot:  cli.RequireSecureBoot,
+		SBAT:               cli.SBAT,
+		RequireSBAT:        cli.RequireSBAT,
+		Checksums:          cli.Checksums,
+	}
+	if s.VerifierConfig.hasVerifier() {
+		s.VerifierConfig.apply(&pa, base)
+	} else {
+		cli.verifierConfig().apply(&pa, "")
+		s.VerifierConfig.applyLog(&pa, base)
+	}
+	if len(pa.RequireAttestation) == 0 {
+		pa.RequireAttestation = cli.RequireAttestation
+	}
+	if s.Destination != "" {
+		pa.Destination = releasePath(base, s.Destination)
+	}
+	if s.Plain != nil {
+		pa.Plain = *s.Plain
+	}
+
+	if (s.Repository == "") == (s.ForImage == "") {
+		return pa, errors.New("either repository or for-image is required")
+	}
+	for _, r := range []string{s.Repository, s.ForImage} {
+		if r == "" {
+			continue
+		}
+		if _, err := registry.ParseReference(r); err != nil {
+			return pa, err
+		}
+	}
+	if s.ForImage != "" && len(s.Tags) > 0 {
+		return pa, errors.New("tags cannot be used with for-image")
+	}
+	for _, t := range s.Tags {
+		if _, err := path.Match(t, ""); err != nil {
+			return pa, fmt.Errorf("invalid tag pattern %s: %w", t, err)
+		}
+	}
+	if err := pa.parseFilters(); err != nil {
+		return pa, err
+	}
+	for _, o := range s.OS {
+		if !AlphanumRegexp.MatchString(o) {
+			return pa, fmt.Errorf("invalid character in OS name %s", o)
+		}
+	}
+	if err := pa.checkVerification(); err != nil {
+		return pa, err
+	}
+	if pa.Destination == "" {
+		return pa, errors.New("destination is required")
+	}
+
+	return pa, nil
+}
@@ -0,0 +1,536 @@
+package nboci
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+
+	_ "github.com/notaryproject/notation-core-go/signature/cose"
+	_ "github.com/notaryproject/notation-core-go/signature/jws"
+	notationx509 "github.com/notaryproject/notation-core-go/x509"
+	"github.com/notaryproject/notation-go"
+	notationregistry "github.com/notaryproject/notation-go/registry"
+	notationverifier "github.com/notaryproject/notation-go/verifier"
+	"github.com/notaryproject/notation-go/verifier/trustpolicy"
+	"github.com/notaryproject/notation-go/verifier/truststore"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sigstore/cosign/v2/cmd/cosign/cli/fulcio"
+	"github.com/sigstore/cosign/v2/cmd/cosign/cli/options"
+	"github.com/sigstore/cosign/v2/cmd/cosign/cli/rekor"
+	"github.com/sigstore/cosign/v2/pkg/cosign"
+	sigs "github.com/sigstore/cosign/v2/pkg/signature"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/tuf"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+// VerificationResult is a successful signature verification of a manifest or index.
+type VerificationResult struct {
+	Verifier  string     `json:"verifier"`
+	Reference string     `json:"reference"`
+	Digest    string     `json:"digest"`
+	Tag       string     `json:"tag,omitempty"`
+	Signer    string     `json:"signer"`
+	Issuer    string     `json:"issuer,omitempty"`
+	KeyID     string     `json:"keyId,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Rule      string     `json:"rule,omitempty"`
+
+	Attestations []string `json:"attestations,omitempty"`
+}
+
+// Verifier verifies signatures of manifests and indexes in a repository.
+type Verifier interface {
+	// Verify verifies signature of the exact manifest or index digest and returns the result, an
+	// error is returned when there is no valid signature.
+	Verify(ctx context.Context, repo *remote.Repository, desc ocispec.Descriptor) (*VerificationResult, error)
+}
+
+// isKeyless returns true when pull arguments require keyless signatures.
+func (args PullArgs) isKeyless() bool {
+	return args.CertificateIdentity != "" || args.CertificateIdentityRegexp != "" ||
+		args.CertificateOIDCIssuer != "" || args.CertificateOIDCIssuerRegexp != ""
+}
+
+// isNotation returns true when pull arguments require notation signatures.
+func (args PullArgs) isNotation() bool {
+	return args.NotationTrustPolicy != "" || args.NotationTrustStore != ""
+}
+
+// checkVerification validates signature verification arguments, keyless verification requires
+// both identity and issuer, notation verification requires both trust policy and trust store.
+func (args PullArgs) checkVerification() error {
+	if args.VerificationPolicy != "" {
+		if args.SignatureKey != "" || args.isKeyless() || args.isNotation() || len(args.RequireAttestation) > 0 {
+			return errors.New("verification policy and signature options are mutually exclusive")
+		}
+		if _, err := loadVerificationPolicy(args.VerificationPolicy, args); err != nil {
+			return fmt.Errorf("invalid verification policy: %w", err)
+		}
+		return nil
+	}
+	if args.SignatureKey != "" && args.isKeyless() {
+		return errors.New("signature key and certificate identity are mutually exclusive")
+	}
+	if args.isNotation() && (args.SignatureKey != "" || args.isKeyless()) {
+		return errors.New("notation and cosign verification are mutually exclusive")
+	}
+	if args.SignatureKey != "" {
+		if _, err := os.Stat(args.SignatureKey); err != nil {
+			return err
+		}
+	}
+	if len(args.RequireAttestation) > 0 && args.SignatureKey == "" && !args.isKeyless() {
+		return errors.New("required attestations need cosign signature verification options")
+	}
+	for _, t := range args.RequireAttestation {
+		if _, err := predicateType(t); err != nil {
+			return err
+		}
+	}
+	if args.CertificateChain != "" && !args.isKeyless() {
+		return errors.New("certificate chain requires certificate identity and issuer")
+	}
+
+	if args.isKeyless() {
+		if (args.CertificateIdentity == "") == (args.CertificateIdentityRegexp == "") {
+			return errors.New("either certificate identity or identity regexp is required")
+		}
+		if (args.CertificateOIDCIssuer == "") == (args.CertificateOIDCIssuerRegexp == "") {
+			return errors.New("either certificate OIDC issuer or issuer regexp is required")
+		}
+		for _, r := range []string{args.CertificateIdentityRegexp, args.CertificateOIDCIssuerRegexp} {
+			if _, err := regexp.Compile(r); err != nil {
+				return fmt.Errorf("invalid regexp %s: %w", r, err)
+			}
+		}
+		if args.CertificateChain != "" {
+			if _, err := os.Stat(args.CertificateChain); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, f := range []string{args.RekorPublicKey, args.CTLogPublicKey} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			return err
+		}
+	}
+	// offline verification must not fall back to TUF root, Fulcio or Rekor requests
+	if args.Offline && (args.SignatureKey != "" || args.isKeyless()) {
+		if !args.IgnoreTlog && args.RekorPublicKey == "" {
+			return errors.New("offline verification requires transparency log public key or ignoring transparency log")
+		}
+		if args.isKeyless() && args.CertificateChain == "" {
+			return errors.New("offline keyless verification requires certificate chain")
+		}
+		if args.isKeyless() && !args.IgnoreSCT && args.CTLogPublicKey == "" {
+			return errors.New("offline keyless verification requires certificate transparency log public key or ignoring SCT")
+		}
+	}
+
+	if args.isNotation() {
+		if args.NotationTrustPolicy == "" || args.NotationTrustStore == "" {
+			return errors.New("notation verification requires both trust policy and trust store")
+		}
+		if _, err := newNotationVerifier(args); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newVerifier returns verifier selected by pull arguments, or nil when signatures are not
+// verified.
+func newVerifier(args PullArgs) (Verifier, error) {
+	switch {
+	case args.isNotation():
+		return newNotationVerifier(args)
+	case args.SignatureKey != "" || args.isKeyless():
+		return &cosignVerifier{args: args}, nil
+	}
+	return nil, nil
+}
+
+// verifySignature verifies signature of an artifact digest when pull arguments or verification
+// policy require it and returns true when the artifact should be pulled, with verification result
+// when it was verified.
+func verifySignature(ctx context.Context, repo *remote.Repository, info *artifactInfo, args PullArgs) (*VerificationResult, bool, error) {
+	if args.Policy != nil {
+		return args.Policy.admit(ctx, repo, info)
+	}
+	if args.Verifier == nil {
+		return nil, true, nil
+	}
+
+	Debug("checking signature of", reference(repo, info.tag), info.desc.Digest.String())
+	result, err := args.Verifier.Verify(ctx, repo, info.desc)
+	if err != nil {
+		return nil, false, err
+	}
+	result.Tag = info.tag
+	Printf("verified %s (%s) signed by %s using %s\n", reference(repo, info.tag), shortDigest(result.Digest), result.Signer, result.Verifier)
+
+	return result, true, nil
+}
+
+// VerificationFile is written into every pulled directory with the verification result of the
+// installed manifest or index.
+const VerificationFile = "verification.json"
+
+// writeVerification records verification result in a directory, a stale record is removed when
+// the artifact was pulled without verification.
+func writeVerification(dirname string, result *VerificationResult) error {
+	filename := filepath.Join(dirname, VerificationFile)
+	if result == nil {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// cosignVerifier verifies cosign signatures with a public key or keyless certificates.
+type cosignVerifier struct {
+	args  PullArgs
+	opts  *cosign.CheckOpts
+	keyID string
+}
+
+// checkOpts returns cosign verification options with trusted keys, roots and transparency
+// logs, they are loaded on first use.
+func (v *cosignVerifier) checkOpts(ctx context.Context) (*cosign.CheckOpts, error) {
+	if v.opts != nil {
+		return v.opts, nil
+	}
+	args := v.args
+
+	ro := registryOptions(args.Plain)
+	clientOpts, err := ro.ClientOpts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	co := &cosign.CheckOpts{
+		RegistryClientOpts: clientOpts,
+		// payload must reference the verified digest
+		ClaimVerifier: cosign.SimpleClaimVerifier,
+		IgnoreTlog:    args.IgnoreTlog,
+		IgnoreSCT:     args.IgnoreSCT,
+		Offline:       args.Offline,
+	}
+
+	if !args.IgnoreTlog {
+		if args.RekorPublicKey != "" {
+			co.RekorPubKeys, err = loadLogPublicKey(args.RekorPublicKey)
+		} else {
+			co.RekorPubKeys, err = cosign.GetRekorPubs(ctx)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot load transparency log public keys: %w", err)
+		}
+		if !args.Offline {
+			rekorURL := args.RekorURL
+			if rekorURL == "" {
+				rekorURL = options.DefaultRekorURL
+			}
+			co.RekorClient, err = rekor.NewClient(rekorURL)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if args.SignatureKey != "" {
+		co.SigVerifier, err = sigs.PublicKeyFromKeyRef(ctx, args.SignatureKey)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load public key: %w", err)
+		}
+		pub, err := co.SigVerifier.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		v.keyID, err = publicKeyID(pub)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		co.Identities = []cosign.Identity{{
+			Subject:       args.CertificateIdentity,
+			SubjectRegExp: args.CertificateIdentityRegexp,
+			Issuer:        args.CertificateOIDCIssuer,
+			IssuerRegExp:  args.CertificateOIDCIssuerRegexp,
+		}}
+		if args.CertificateChain != "" {
+			data, err := os.ReadFile(args.CertificateChain)
+			if err != nil {
+				return nil, err
+			}
+			chain, err := cryptoutils.UnmarshalCertificatesFromPEM(data)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse certificate chain: %w", err)
+			}
+			if len(chain) == 0 {
+				return nil, errors.New("no certificates in certificate chain")
+			}
+			co.RootCerts = x509.NewCertPool()
+			co.RootCerts.AddCert(chain[len(chain)-1])
+			co.IntermediateCerts = x509.NewCertPool()
+			for _, c := range chain[:len(chain)-1] {
+				co.IntermediateCerts.AddCert(c)
+			}
+		} else {
+			co.RootCerts, err = fulcio.GetRoots()
+			if err != nil {
+				return nil, fmt.Errorf("cannot get Fulcio roots: %w", err)
+			}
+			co.IntermediateCerts, err = fulcio.GetIntermediates()
+			if err != nil {
+				return nil, fmt.Errorf("cannot get Fulcio intermediates: %w", err)
+			}
+		}
+		if !args.IgnoreSCT {
+			if args.CTLogPublicKey != "" {
+				co.CTLogPubKeys, err = loadLogPublicKey(args.CTLogPublicKey)
+			} else {
+				co.CTLogPubKeys, err = cosign.GetCTLogPubs(ctx)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("cannot load certificate transparency log public keys: %w", err)
+			}
+		}
+	}
+
+	v.opts = co
+	return co, nil
+}
+
+func (v *cosignVerifier) Verify(ctx context.Context, repo *remote.Repository, desc ocispec.Descriptor) (*VerificationResult, error) {
+	args := v.args
+	co, err := v.checkOpts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := reference(repo, desc.Digest.String())
+	digestRef, err := name.NewDigest(ref, nameOptions(args.Plain)...)
+	if err != nil {
+		return nil, err
+	}
+	signatures, _, err := cosign.VerifyImageSignatures(ctx, digestRef, co)
+	if err != nil {
+		if args.Offline && !args.IgnoreTlog {
+			return nil, fmt.Errorf("%w (offline verification accepts only transparency log entries bundled in signatures and signed by the trusted log key)", err)
+		}
+		return nil, err
+	}
+
+	result := &VerificationResult{
+		Verifier:  "cosign",
+		Reference: ref,
+		Digest:    desc.Digest.String(),
+		Signer:    args.SignatureKey,
+		KeyID:     v.keyID,
+	}
+	sig := signatures[0]
+	if args.SignatureKey == "" {
+		cert, err := sig.Cert()
+		if err != nil || cert == nil {
+			return nil, fmt.Errorf("cannot read signing certificate: %w", err)
+		}
+		result.Signer = strings.Join(cryptoutils.GetSubjectAlternateNames(cert), ",")
+		result.Issuer = (&cosign.CertExtensions{Cert: cert}).GetIssuer()
+		result.KeyID, err = publicKeyID(cert.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if bundle, err := sig.Bundle(); err == nil && bundle != nil {
+		ts := time.Unix(bundle.Payload.IntegratedTime, 0).UTC()
+		result.Timestamp = &ts
+	}
+
+	return result, nil
+}
+
+// VerifyAttestations verifies cosign attestations of the exact manifest digest and returns
+// predicate types of the verified in-toto statements.
+func (v *cosignVerifier) VerifyAttestations(ctx context.Context, repo *remote.Repository, desc ocispec.Descriptor) ([]string, error) {
+	co, err := v.checkOpts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// statement subject must reference the verified digest
+	aco := *co
+	aco.ClaimVerifier = cosign.IntotoSubjectClaimVerifier
+
+	digestRef, err := name.NewDigest(reference(repo, desc.Digest.String()), nameOptions(v.args.Plain)...)
+	if err != nil {
+		return nil, err
+	}
+	atts, _, err := cosign.VerifyImageAttestations(ctx, digestRef, &aco)
+	if err != nil {
+		return nil, err
+	}
+
+	uris := make([]string, 0, len(atts))
+	for _, att := range atts {
+		statement, err := attestationStatement(att)
+		if err != nil {
+			return nil, err
+		}
+		uris = append(uris, statement.PredicateType)
+	}
+
+	return uris, nil
+}
+
+// loadLogPublicKey loads trusted transparency log public key from a PEM file.
+func loadLogPublicKey(filename string) (*cosign.TrustedTransparencyLogPubKeys, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	keys := cosign.NewTrustedTransparencyLogPubKeys()
+	if err := keys.AddTransparencyLogPubKey(data, tuf.Active); err != nil {
+		return nil, err
+	}
+
+	return &keys, nil
+}
+
+// publicKeyID returns hex encoded SHA-256 fingerprint of DER encoded public key.
+func publicKeyID(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// notationVerifier verifies Notary Project signatures stored as referrers against a trust policy
+// and a trust store.
+type notationVerifier struct {
+	verifier notation.Verifier
+}
+
+// newNotationVerifier loads and validates notation trust policy document.
+func newNotationVerifier(args PullArgs) (*notationVerifier, error) {
+	data, err := os.ReadFile(args.NotationTrustPolicy)
+	if err != nil {
+		return nil, err
+	}
+	var policy trustpolicy.Document
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("cannot parse trust policy %s: %w", args.NotationTrustPolicy, err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(args.NotationTrustStore); err != nil {
+		return nil, err
+	}
+
+	v, err := notationverifier.New(&policy, dirTrustStore(args.NotationTrustStore), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &notationVerifier{verifier: v}, nil
+}
+
+func (v *notationVerifier) Verify(ctx context.Context, repo *remote.Repository, desc ocispec.Descriptor) (*VerificationResult, error) {
+	ref := reference(repo, desc.Digest.String())
+	_, outcomes, err := notation.Verify(ctx, v.verifier, notationregistry.NewRepository(repo), notation.VerifyOptions{
+		ArtifactReference:    ref,
+		MaxSignatureAttempts: 50,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &VerificationResult{
+		Verifier:  "notation",
+		Reference: ref,
+		Digest:    desc.Digest.String(),
+	}
+	for _, o := range outcomes {
+		// skip level returns an outcome without any verified signature
+		if o.VerificationLevel != nil && o.VerificationLevel.Name == trustpolicy.LevelSkip.Name {
+			return nil, fmt.Errorf("trust policy skips signature verification of %s", ref)
+		}
+		if o.Error != nil || o.EnvelopeContent == nil {
+			continue
+		}
+		ts := o.EnvelopeContent.SignerInfo.SignedAttributes.SigningTime
+		result.Timestamp = &ts
+		if chain := o.EnvelopeContent.SignerInfo.CertificateChain; len(chain) > 0 {
+			result.Signer = chain[0].Subject.String()
+			result.Issuer = chain[0].Issuer.String()
+			sum := sha256.Sum256(chain[0].Raw)
+			result.KeyID = hex.EncodeToString(sum[:])
+		}
+		if o.VerificationLevel != nil && o.VerificationLevel.Name != trustpolicy.LevelStrict.Name {
+			result.Signer += fmt.Sprintf(" (%s)", o.VerificationLevel.Name)
+		}
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("no verified notation signature of %s", ref)
+}
+
+// dirTrustStore is notation trust store directory with certificates in x509/TYPE/NAME
+// subdirectories.
+type dirTrustStore string
+
+func (d dirTrustStore) GetCertificates(_ context.Context, storeType truststore.Type, namedStore string) ([]*x509.Certificate, error) {
+	if filepath.Base(namedStore) != namedStore {
+		return nil, fmt.Errorf("invalid trust store name %s", namedStore)
+	}
+	dir := filepath.Join(string(d), "x509", string(storeType), namedStore)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read trust store %s: %w", namedStore, err)
+	}
+
+	certs := make([]*x509.Certificate, 0)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		c, err := notationx509.ReadCertificateFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read certificate %s: %w", e.Name(), err)
+		}
+		certs = append(certs, c...)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("trust store %s has no certificates", namedStore)
+	}
+
+	return certs, nil
+}
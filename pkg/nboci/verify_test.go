@@ -0,0 +1,532 @@
+package nboci
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	ggcrremote "github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/notaryproject/notation-go"
+	notationregistry "github.com/notaryproject/notation-go/registry"
+	notationsigner "github.com/notaryproject/notation-go/signer"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sigstore/cosign/v2/pkg/cosign/bundle"
+	"github.com/sigstore/cosign/v2/pkg/oci/mutate"
+	ociremote "github.com/sigstore/cosign/v2/pkg/oci/remote"
+	"github.com/sigstore/cosign/v2/pkg/oci/static"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature/payload"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+// testImage is an image pushed into an in-memory registry, signatures are attached by digest.
+type testImage struct {
+	repo   *remote.Repository
+	desc   ocispec.Descriptor
+	digest name.Digest
+}
+
+// pushTestImage starts an in-memory registry and pushes a random image into it.
+func pushTestImage(t *testing.T) *testImage {
+	t.Helper()
+	// registry credentials are read from the home directory
+	t.Setenv("HOME", t.TempDir())
+
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+	repository := strings.TrimPrefix(server.URL, "http://") + "/netboot"
+
+	img, err := random.Image(256, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := name.NewTag(repository+":test", name.Insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ggcrremote.Write(tag, img); err != nil {
+		t.Fatal(err)
+	}
+	h, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := newRepository(repository, true)
+	desc, err := repo.Resolve(context.Background(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testImage{repo: repo, desc: desc, digest: tag.Context().Digest(h.String())}
+}
+
+// signedPayload returns cosign payload of the image and its signature made by the key.
+func (img *testImage) signedPayload(t *testing.T, key *ecdsa.PrivateKey) ([]byte, []byte) {
+	t.Helper()
+	data, err := json.Marshal(payload.Cosign{Image: img.digest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	sig, err := key.Sign(rand.Reader, sum[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data, sig
+}
+
+// sign attaches a cosign signature of the image made by the key with optional certificate and
+// transparency log bundle.
+func (img *testImage) sign(t *testing.T, key *ecdsa.PrivateKey, opts ...static.Option) {
+	t.Helper()
+	data, sig := img.signedPayload(t, key)
+	img.attach(t, data, sig, opts...)
+}
+
+func (img *testImage) attach(t *testing.T, data, sig []byte, opts ...static.Option) {
+	t.Helper()
+	ociSig, err := static.NewSignature(data, base64.StdEncoding.EncodeToString(sig), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	se, err := ociremote.SignedEntity(img.digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	se, err = mutate.AttachSignatureToEntity(se, ociSig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ociremote.WriteSignatures(img.digest.Repository, se); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (img *testImage) verify(args PullArgs) (*VerificationResult, error) {
+	args.Plain = true
+	if err := args.checkVerification(); err != nil {
+		return nil, err
+	}
+	v, err := newVerifier(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.Verify(context.Background(), img.repo, img.desc)
+}
+
+// writeTestFile writes a file into the test temporary directory and returns its path.
+func writeTestFile(t *testing.T, filename string, data []byte) string {
+	t.Helper()
+	filename = filepath.Join(t.TempDir(), filename)
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return filename
+}
+
+func generateTestKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := cryptoutils.MarshalPublicKeyToPEM(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, writeTestFile(t, "cosign.pub", pub)
+}
+
+// generateTestCA returns a self-signed CA certificate and its key.
+func generateTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "nboci test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+// generateTestLeaf returns a Fulcio-like code signing certificate with email identity and OIDC
+// issuer extension, and its key.
+func generateTestLeaf(t *testing.T, identity, issuer string, ca *x509.Certificate, caKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		EmailAddresses: []string{identity},
+		NotBefore:      time.Now().Add(-time.Minute),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		ExtraExtensions: []pkix.Extension{{
+			// Fulcio OIDC issuer extension
+			Id:    asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1},
+			Value: []byte(issuer),
+		}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func TestVerifyKey(t *testing.T) {
+	img := pushTestImage(t)
+	key, keyFile := generateTestKey(t)
+	_, otherFile := generateTestKey(t)
+
+	if _, err := img.verify(PullArgs{SignatureKey: keyFile, IgnoreTlog: true}); err == nil {
+		t.Fatal("unsigned image verified")
+	}
+
+	img.sign(t, key)
+	result, err := img.verify(PullArgs{SignatureKey: keyFile, IgnoreTlog: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Verifier != "cosign" || result.Signer != keyFile || result.Digest != img.desc.Digest.String() {
+		t.Errorf("unexpected result %+v", result)
+	}
+	id, err := publicKeyID(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.KeyID != id {
+		t.Errorf("key id %s, expected %s", result.KeyID, id)
+	}
+
+	if _, err := img.verify(PullArgs{SignatureKey: otherFile, IgnoreTlog: true}); err == nil {
+		t.Fatal("signature verified with a different key")
+	}
+}
+
+func TestVerifyKeyless(t *testing.T) {
+	const (
+		identity = "builder@example.com"
+		issuer   = "https://issuer.example.com"
+	)
+
+	img := pushTestImage(t)
+	root, rootKey := generateTestCA(t)
+	leaf, leafKey := generateTestLeaf(t, identity, issuer, root, rootKey)
+	leafPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw})
+	rootPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+	chain := writeTestFile(t, "chain.pem", rootPEM)
+	img.sign(t, leafKey, static.WithCertChain(leafPEM, rootPEM))
+
+	other, _ := generateTestCA(t)
+	otherChain := writeTestFile(t, "other.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: other.Raw}))
+
+	tests := []struct {
+		name string
+		args PullArgs
+		ok   bool
+	}{
+		{"identity and issuer", PullArgs{CertificateIdentity: identity, CertificateOIDCIssuer: issuer}, true},
+		{"identity mismatch", PullArgs{CertificateIdentity: "other@example.com", CertificateOIDCIssuer: issuer}, false},
+		{"issuer mismatch", PullArgs{CertificateIdentity: identity, CertificateOIDCIssuer: "https://other.example.com"}, false},
+		{"identity regexp", PullArgs{CertificateIdentityRegexp: `^builder@example\.com$`, CertificateOIDCIssuer: issuer}, true},
+		{"identity regexp mismatch", PullArgs{CertificateIdentityRegexp: `^deployer@`, CertificateOIDCIssuer: issuer}, false},
+		{"issuer regexp", PullArgs{CertificateIdentity: identity, CertificateOIDCIssuerRegexp: `^https://issuer\.`}, true},
+		{"issuer regexp mismatch", PullArgs{CertificateIdentity: identity, CertificateOIDCIssuerRegexp: `^https://other\.`}, false},
+		{"untrusted chain", PullArgs{CertificateIdentity: identity, CertificateOIDCIssuer: issuer, CertificateChain: otherChain}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			args.IgnoreTlog, args.IgnoreSCT = true, true
+			if args.CertificateChain == "" {
+				args.CertificateChain = chain
+			}
+
+			result, err := img.verify(args)
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("verification succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Signer != identity || result.Issuer != issuer {
+				t.Errorf("unexpected signer %s and issuer %s", result.Signer, result.Issuer)
+			}
+		})
+	}
+}
+
+// writeNotationConfig writes notation trust policy of the level and trust store with the CA
+// certificate and returns their paths.
+func writeNotationConfig(t *testing.T, level string, ca *x509.Certificate) (string, string) {
+	t.Helper()
+	statement := map[string]any{
+		"name":                  "netboot",
+		"registryScopes":        []string{"*"},
+		"signatureVerification": map[string]string{"level": level},
+		"trustStores":           []string{"ca:netboot"},
+		"trustedIdentities":     []string{"*"},
+	}
+	if level == "skip" {
+		delete(statement, "trustStores")
+		delete(statement, "trustedIdentities")
+	}
+	policy, err := json.Marshal(map[string]any{"version": "1.0", "trustPolicies": []any{statement}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := t.TempDir()
+	dir := filepath.Join(store, "x509", "ca", "netboot")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ca.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return writeTestFile(t, "trustpolicy.json", policy), store
+}
+
+func TestVerifyNotation(t *testing.T) {
+	img := pushTestImage(t)
+	root, rootKey := generateTestCA(t)
+	leaf, leafKey := generateTestLeaf(t, "builder@example.com", "https://issuer.example.com", root, rootKey)
+	other, _ := generateTestCA(t)
+
+	verify := func(level string, ca *x509.Certificate) (*VerificationResult, error) {
+		policy, store := writeNotationConfig(t, level, ca)
+		return img.verify(PullArgs{NotationTrustPolicy: policy, NotationTrustStore: store})
+	}
+
+	if _, err := verify("strict", root); err == nil {
+		t.Fatal("unsigned image verified")
+	}
+
+	signer, err := notationsigner.New(leafKey, []*x509.Certificate{leaf, root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = notation.Sign(context.Background(), signer, notationregistry.NewRepository(img.repo), notation.SignOptions{
+		SignerSignOptions: notation.SignerSignOptions{SignatureMediaType: "application/jose+json"},
+		ArtifactReference: img.desc.Digest.String(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := verify("strict", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Verifier != "notation" || result.Issuer != root.Subject.String() || result.Digest != img.desc.Digest.String() {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if result.Timestamp == nil {
+		t.Error("missing signing time")
+	}
+
+	if _, err := verify("strict", other); err == nil {
+		t.Fatal("signature verified with a different CA")
+	}
+	if _, err := verify("skip", root); err == nil || !strings.Contains(err.Error(), "skips signature verification") {
+		t.Fatalf("expected skip level error, got %v", err)
+	}
+}
+
+func TestCheckVerification(t *testing.T) {
+	key := writeTestFile(t, "cosign.pub", nil)
+	chain := writeTestFile(t, "chain.pem", nil)
+
+	tests := []struct {
+		name string
+		args PullArgs
+		err  string
+	}{
+		{"no verification", PullArgs{}, ""},
+		{"key", PullArgs{SignatureKey: key}, ""},
+		{"missing key", PullArgs{SignatureKey: key + ".missing"}, "no such file"},
+		{"keyless", PullArgs{CertificateIdentity: "a@example.com", CertificateOIDCIssuer: "https://example.com"}, ""},
+		{"key and identity", PullArgs{SignatureKey: key, CertificateIdentity: "a@example.com"}, "mutually exclusive"},
+		{"notation and key", PullArgs{SignatureKey: key, NotationTrustPolicy: "policy.json"}, "mutually exclusive"},
+		{"identity without issuer", PullArgs{CertificateIdentity: "a@example.com"}, "issuer"},
+		{"issuer without identity", PullArgs{CertificateOIDCIssuer: "https://example.com"}, "identity"},
+		{"identity and identity regexp", PullArgs{CertificateIdentity: "a", CertificateIdentityRegexp: "a", CertificateOIDCIssuer: "b"}, "identity"},
+		{"issuer and issuer regexp", PullArgs{CertificateIdentity: "a", CertificateOIDCIssuer: "b", CertificateOIDCIssuerRegexp: "b"}, "issuer"},
+		{"invalid regexp", PullArgs{CertificateIdentityRegexp: "(", CertificateOIDCIssuer: "b"}, "invalid regexp"},
+		{"chain without identity", PullArgs{CertificateChain: chain}, "certificate chain"},
+		{"attestation without verification", PullArgs{RequireAttestation: []string{"slsaprovenance"}}, "signature verification"},
+		{"attestation with notation", PullArgs{RequireAttestation: []string{"slsaprovenance"}, NotationTrustPolicy: "policy.json"}, "cosign signature verification"},
+		{"offline without log key", PullArgs{SignatureKey: key, Offline: true}, "transparency log public key"},
+		{"offline ignoring log", PullArgs{SignatureKey: key, Offline: true, IgnoreTlog: true}, ""},
+		{"offline keyless without chain", PullArgs{CertificateIdentity: "a", CertificateOIDCIssuer: "b", Offline: true, IgnoreTlog: true, IgnoreSCT: true}, "certificate chain"},
+		{"offline keyless without SCT key", PullArgs{CertificateIdentity: "a", CertificateOIDCIssuer: "b", CertificateChain: chain, Offline: true, IgnoreTlog: true}, "certificate transparency"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.args.checkVerification()
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("error %v, expected %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// signBundle returns a transparency log bundle of a hashedrekord entry with the payload signature
+// and public key, with signed entry timestamp made by the log key.
+func signBundle(t *testing.T, logKey *ecdsa.PrivateKey, data, sig, pub []byte) *bundle.RekorBundle {
+	t.Helper()
+	sum := sha256.Sum256(data)
+	entry := map[string]any{
+		"apiVersion": "0.0.1",
+		"kind":       "hashedrekord",
+		"spec": map[string]any{
+			"data": map[string]any{
+				"hash": map[string]any{"algorithm": "sha256", "value": hex.EncodeToString(sum[:])},
+			},
+			"signature": map[string]any{
+				"content":   base64.StdEncoding.EncodeToString(sig),
+				"publicKey": map[string]any{"content": base64.StdEncoding.EncodeToString(pub)},
+			},
+		},
+	}
+	body, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logID, err := publicKeyID(logKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rb := &bundle.RekorBundle{
+		Payload: bundle.RekorPayload{
+			Body:           base64.StdEncoding.EncodeToString(body),
+			IntegratedTime: time.Now().Unix(),
+			LogIndex:       1,
+			LogID:          logID,
+		},
+	}
+	// maps marshal with sorted keys, which is the canonical form for these values
+	set, err := json.Marshal(map[string]any{
+		"body":           rb.Payload.Body,
+		"integratedTime": rb.Payload.IntegratedTime,
+		"logIndex":       rb.Payload.LogIndex,
+		"logID":          rb.Payload.LogID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setSum := sha256.Sum256(set)
+	rb.SignedEntryTimestamp, err = ecdsa.SignASN1(rand.Reader, logKey, setSum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rb
+}
+
+func TestVerifyOffline(t *testing.T) {
+	img := pushTestImage(t)
+	key, keyFile := generateTestKey(t)
+	logKey, logKeyFile := generateTestKey(t)
+	_, otherLogKeyFile := generateTestKey(t)
+	pub, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, sig := img.signedPayload(t, key)
+	img.attach(t, data, sig, static.WithBundle(signBundle(t, logKey, data, sig, pub)))
+
+	result, err := img.verify(PullArgs{SignatureKey: keyFile, Offline: true, RekorPublicKey: logKeyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Timestamp == nil {
+		t.Error("missing transparency log timestamp")
+	}
+
+	if _, err := img.verify(PullArgs{SignatureKey: keyFile, Offline: true, RekorPublicKey: otherLogKeyFile}); err == nil {
+		t.Fatal("bundle verified with a different transparency log key")
+	}
+
+	_, err = img.verify(PullArgs{SignatureKey: keyFile, Offline: true})
+	if err == nil || !strings.Contains(err.Error(), "transparency log public key") {
+		t.Fatalf("error %v, expected missing transparency log public key", err)
+	}
+}
+
+func TestVerifyOfflineWithoutBundle(t *testing.T) {
+	img := pushTestImage(t)
+	key, keyFile := generateTestKey(t)
+	_, logKeyFile := generateTestKey(t)
+	img.sign(t, key)
+
+	_, err := img.verify(PullArgs{SignatureKey: keyFile, Offline: true, RekorPublicKey: logKeyFile})
+	if err == nil || !strings.Contains(err.Error(), "offline verification accepts only") {
+		t.Fatalf("error %v, expected offline bundle error", err)
+	}
+}